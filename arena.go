@@ -0,0 +1,89 @@
+package mustache
+
+// arena allocates the elements of a template, and of the partials parsed
+// with it, in chunks, so that parsing makes a few large allocations rather
+// than one per tag. A nil arena allocates each element on its own.
+type arena struct {
+    texts    []textElement
+    vars     []varElement
+    comments []commentElement
+    sections []sectionElement
+    partials []partialElement
+}
+
+// arenaChunk returns the capacity of the chunk following one of capacity
+// n: chunks start small, for small templates, and double up to a limit.
+func arenaChunk(n int) int {
+    switch {
+    case n < 4:
+        return 4
+    case n >= 1024:
+        return 1024
+    }
+    return 2 * n
+}
+
+func (a *arena) text(e textElement) *textElement {
+    if a == nil {
+        p := new(textElement)
+        *p = e
+        return p
+    }
+    if len(a.texts) == cap(a.texts) {
+        a.texts = make([]textElement, 0, arenaChunk(cap(a.texts)))
+    }
+    a.texts = append(a.texts, e)
+    return &a.texts[len(a.texts)-1]
+}
+
+func (a *arena) variable(e varElement) *varElement {
+    if a == nil {
+        p := new(varElement)
+        *p = e
+        return p
+    }
+    if len(a.vars) == cap(a.vars) {
+        a.vars = make([]varElement, 0, arenaChunk(cap(a.vars)))
+    }
+    a.vars = append(a.vars, e)
+    return &a.vars[len(a.vars)-1]
+}
+
+func (a *arena) comment(e commentElement) *commentElement {
+    if a == nil {
+        p := new(commentElement)
+        *p = e
+        return p
+    }
+    if len(a.comments) == cap(a.comments) {
+        a.comments = make([]commentElement, 0, arenaChunk(cap(a.comments)))
+    }
+    a.comments = append(a.comments, e)
+    return &a.comments[len(a.comments)-1]
+}
+
+func (a *arena) section(e sectionElement) *sectionElement {
+    if a == nil {
+        p := new(sectionElement)
+        *p = e
+        return p
+    }
+    if len(a.sections) == cap(a.sections) {
+        a.sections = make([]sectionElement, 0, arenaChunk(cap(a.sections)))
+    }
+    a.sections = append(a.sections, e)
+    return &a.sections[len(a.sections)-1]
+}
+
+func (a *arena) partial(e partialElement) *partialElement {
+    if a == nil {
+        p := new(partialElement)
+        *p = e
+        return p
+    }
+    if len(a.partials) == cap(a.partials) {
+        a.partials = make([]partialElement, 0, arenaChunk(cap(a.partials)))
+    }
+    a.partials = append(a.partials, e)
+    return &a.partials[len(a.partials)-1]
+}
@@ -0,0 +1,37 @@
+package mustache
+
+// Budget accounts for the work renders do and bounds it, so that a platform
+// rendering its tenants' templates can bill them for it and stop runaway
+// renders. Renders given a Budget add to its counters, which keep counting
+// across the renders sharing it, and a render taking a counter past its
+// limit fails with a *LimitError. A Budget may not be used by several
+// renders at once.
+//
+// Limits on Elements or Bytes bound what hostile data can make a template
+// do: sections nested over lists multiply, so that a few sections over a
+// list of a hundred items render billions of elements. A render of data
+// from untrusted sources should be given a fresh Budget with such limits.
+type Budget struct {
+    // Elements counts the text runs and tags rendered, the tags in a
+    // section counting once for each item of its list.
+    Elements int
+    // Bytes counts the bytes written.
+    Bytes int
+    // Lookups counts the names looked up in the data, including the
+    // arguments of helpers.
+    Lookups int
+    // Partials counts the partials rendered.
+    Partials int
+
+    // The limits on each counter; zero means no limit.
+    MaxElements, MaxBytes, MaxLookups, MaxPartials int
+}
+
+// spend adds n to a counter of the render's budget, if it has one, and
+// fails the render if the counter goes past max.
+func (r *renderer) spend(counter *int, n, max int, limit string) {
+    *counter += n
+    if max > 0 && *counter > max && r.err == nil {
+        r.err = &LimitError{r.line, limit, max}
+    }
+}
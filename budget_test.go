@@ -0,0 +1,69 @@
+package mustache
+
+import (
+    "io/ioutil"
+    "strings"
+    "testing"
+)
+
+func TestBudget(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"item": "<li>{{name}}</li>"}}
+    tmpl, err := ParseStringPartials("<ul>\n{{#items}}{{>item}}{{/items}}</ul>", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data := map[string]interface{}{"items": []map[string]string{{"name": "a"}, {"name": "b"}}}
+
+    var b Budget
+    var sb strings.Builder
+    if err := tmpl.FRenderOptions(&sb, RenderOptions{Budget: &b}, data); err != nil {
+        t.Fatal(err)
+    }
+    // text, section, 2 × (partial, text, variable, text), text
+    expected := Budget{Elements: 11, Bytes: len(sb.String()), Lookups: 3, Partials: 2}
+    if b != expected {
+        t.Fatalf("expected %+v got %+v", expected, b)
+    }
+
+    // counters add up across renders
+    tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Budget: &b}, data)
+    if b.Elements != 22 || b.Partials != 4 {
+        t.Fatalf("expected the counts to double, got %+v", b)
+    }
+
+    tests := []struct {
+        budget   Budget
+        expected string
+    }{
+        {Budget{MaxElements: 2}, "line 2: elements exceeds the limit of 2"},
+        {Budget{MaxBytes: 10}, "line 1: bytes exceeds the limit of 10, in partial \"item\" included from the template on line 2"},
+        {Budget{MaxLookups: 2}, "line 1: lookups exceeds the limit of 2, in partial \"item\" included from the template on line 2"},
+        {Budget{MaxPartials: 1}, "line 2: partials exceeds the limit of 1"},
+        {Budget{MaxElements: 11, MaxBytes: 100, MaxLookups: 3, MaxPartials: 2}, ""},
+    }
+    for _, test := range tests {
+        b := test.budget
+        err := tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Budget: &b}, data)
+        if test.expected == "" && err != nil || test.expected != "" && (err == nil || err.Error() != test.expected) {
+            t.Errorf("%+v: expected %q got %v", test.budget, test.expected, err)
+        }
+    }
+}
+
+func TestBudgetExpansion(t *testing.T) {
+    // each section renders its body for every item of the list, so the
+    // innermost tag would be rendered 100⁵ times
+    list := make([]int, 100)
+    tmpl, err := ParseString(`{{#l}}{{#l}}{{#l}}{{#l}}{{#l}}{{.}}{{/l}}{{/l}}{{/l}}{{/l}}{{/l}}`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    b := Budget{MaxElements: 100000}
+    err = tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Budget: &b}, map[string]interface{}{"l": list})
+    if err == nil || err.Error() != "line 1: elements exceeds the limit of 100000" {
+        t.Fatalf("expected the render to be stopped, got %v", err)
+    }
+    if b.Elements != 100001 {
+        t.Fatalf("expected the render to stop at the limit, got %d elements", b.Elements)
+    }
+}
@@ -0,0 +1,136 @@
+package mustache
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "go/token"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// bundleFormat is the version of the bundle format written by WriteBundle.
+const bundleFormat = 1
+
+// bundle is a set of named template sources shipped as a single JSON
+// document, such as {"format": 1, "templates": {"page": "..."}}. Versions
+// given to AddVersion and the sources of variants, by template and variant
+// name, are kept alongside when there are any.
+type bundle struct {
+    Format    int                          `json:"format"`
+    Templates map[string]string            `json:"templates"`
+    Versions  map[string]string            `json:"versions,omitempty"`
+    Variants  map[string]map[string]string `json:"variants,omitempty"`
+}
+
+// LoadBundle reads a bundle written by Registry.WriteBundle into a new
+// Registry.
+func LoadBundle(r io.Reader) (*Registry, error) {
+    var b bundle
+    if err := json.NewDecoder(r).Decode(&b); err != nil {
+        return nil, err
+    }
+    if b.Format != bundleFormat {
+        return nil, fmt.Errorf("unsupported bundle format %d", b.Format)
+    }
+    reg := NewRegistry()
+    for name, src := range b.Templates {
+        reg.AddVersion(name, src, b.Versions[name])
+    }
+    for name, variants := range b.Variants {
+        for variant, src := range variants {
+            reg.AddVariant(name, variant, src)
+        }
+    }
+    return reg, nil
+}
+
+// WriteBundle writes the sources of the registry's templates to w as a
+// single bundle, so that a set of templates can be shipped and versioned
+// as one file and loaded with LoadBundle.
+func (r *Registry) WriteBundle(w io.Writer) error {
+    b := r.bundle()
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.SetEscapeHTML(false)
+    return enc.Encode(b)
+}
+
+// bundle returns the contents of the registry as a bundle.
+func (r *Registry) bundle() bundle {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    b := bundle{Format: bundleFormat, Templates: make(map[string]string, len(r.sources))}
+    for name, src := range r.sources {
+        if !strings.Contains(name, variantSep) {
+            b.Templates[name] = src
+        }
+    }
+    for name, version := range r.versions {
+        if !strings.Contains(name, variantSep) {
+            if b.Versions == nil {
+                b.Versions = map[string]string{}
+            }
+            b.Versions[name] = version
+        }
+    }
+    for name, variants := range r.variants {
+        if b.Variants == nil {
+            b.Variants = map[string]map[string]string{}
+        }
+        b.Variants[name] = make(map[string]string, len(variants))
+        for _, variant := range variants {
+            b.Variants[name][variant] = r.sources[variantKey(name, variant)]
+        }
+    }
+    return b
+}
+
+// WriteGo writes a Go source file for package pkg that declares a Registry
+// variable called name holding the registry's templates, added at init. It
+// is meant for go:generate, for programs that embed their templates in the
+// binary without embed.FS.
+func (r *Registry) WriteGo(w io.Writer, pkg, name string) error {
+    // the file is written as gofmt would, without linking go/format
+    for _, ident := range []string{pkg, name} {
+        if !token.IsIdentifier(ident) {
+            return fmt.Errorf("%q is not a Go identifier", ident)
+        }
+    }
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "// Code generated by mustache; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+    fmt.Fprintf(&buf, "import \"github.com/hoisie/mustache\"\n\n")
+    fmt.Fprintf(&buf, "var %s = mustache.NewRegistry()\n\nfunc init() {\n", name)
+    b := r.bundle()
+    for _, tmpl := range sortedKeys(b.Templates) {
+        if version, ok := b.Versions[tmpl]; ok {
+            fmt.Fprintf(&buf, "\t%s.AddVersion(%s, %s, %s)\n", name, strconv.Quote(tmpl), strconv.Quote(b.Templates[tmpl]), strconv.Quote(version))
+        } else {
+            fmt.Fprintf(&buf, "\t%s.Add(%s, %s)\n", name, strconv.Quote(tmpl), strconv.Quote(b.Templates[tmpl]))
+        }
+    }
+    tmpls := make([]string, 0, len(b.Variants))
+    for tmpl := range b.Variants {
+        tmpls = append(tmpls, tmpl)
+    }
+    sort.Strings(tmpls)
+    for _, tmpl := range tmpls {
+        for _, variant := range sortedKeys(b.Variants[tmpl]) {
+            fmt.Fprintf(&buf, "\t%s.AddVariant(%s, %s, %s)\n", name, strconv.Quote(tmpl), strconv.Quote(variant), strconv.Quote(b.Variants[tmpl][variant]))
+        }
+    }
+    buf.WriteString("}\n")
+    _, err := w.Write(buf.Bytes())
+    return err
+}
+
+func sortedKeys(m map[string]string) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,115 @@
+package mustache
+
+import (
+    "bytes"
+    "go/format"
+    "strings"
+    "testing"
+)
+
+func TestBundle(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "{{>header}}<p>{{body}}</p>")
+    r.Add("header", "<h1>{{title}}</h1>")
+
+    var buf bytes.Buffer
+    if err := r.WriteBundle(&buf); err != nil {
+        t.Fatal(err)
+    }
+    expected := "{\n  \"format\": 1,\n  \"templates\": {\n    \"header\": \"<h1>{{title}}</h1>\",\n    \"page\": \"{{>header}}<p>{{body}}</p>\"\n  }\n}\n"
+    if buf.String() != expected {
+        t.Fatalf("expected %q got %q", expected, buf.String())
+    }
+
+    loaded, err := LoadBundle(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    output, err := loaded.Render("page", map[string]string{"title": "Hi", "body": "text"})
+    if err != nil || output != "<h1>Hi</h1><p>text</p>" {
+        t.Fatalf("unexpected output %q, %v", output, err)
+    }
+
+    if _, err := LoadBundle(strings.NewReader(`{"format": 2, "templates": {}}`)); err == nil || err.Error() != "unsupported bundle format 2" {
+        t.Fatalf("expected an unsupported format error, got %v", err)
+    }
+    if _, err := LoadBundle(strings.NewReader(`{"format": 1`)); err == nil {
+        t.Fatal("expected an error for a truncated bundle")
+    }
+}
+
+func TestBundleVariants(t *testing.T) {
+    r := NewRegistry()
+    r.AddVersion("page", "<p>{{body}}</p>", "v2")
+    r.AddVariant("page", "b", "<div>{{body}}</div>")
+    r.Add("header", "<h1>{{title}}</h1>")
+
+    var buf bytes.Buffer
+    if err := r.WriteBundle(&buf); err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(buf.String(), `\u0000`) {
+        t.Fatalf("variant keys written as templates: %s", buf.String())
+    }
+    loaded, err := LoadBundle(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if names := loaded.Names(); len(names) != 2 || names[0] != "header" || names[1] != "page" {
+        t.Fatalf("unexpected names %q", names)
+    }
+    if src, err := loaded.GetVariant("page", "b"); err != nil || src != "<div>{{body}}</div>" {
+        t.Fatalf("unexpected variant %q, %v", src, err)
+    }
+    if version, err := loaded.Version("page"); err != nil || version != "v2" {
+        t.Fatalf("unexpected version %q, %v", version, err)
+    }
+    output, variant, err := loaded.RenderVariant("page", func(string, []string) string { return "b" }, map[string]string{"body": "x"})
+    if err != nil || variant != "b" || output != "<div>x</div>" {
+        t.Fatalf("unexpected render %q of variant %q, %v", output, variant, err)
+    }
+    if _, err := loaded.GetVariant("page", "c"); err == nil {
+        t.Fatal("expected an error for a missing variant")
+    }
+}
+
+func TestWriteGo(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "{{>header}}\n<p>{{body}}</p>")
+    r.AddVersion("header", "<h1>{{title}}</h1>", "v1")
+    r.AddVariant("page", "b", "<div>{{body}}</div>")
+    r.AddVariant("aside", "b", "<aside>")
+
+    var buf bytes.Buffer
+    if err := r.WriteGo(&buf, "views", "Templates"); err != nil {
+        t.Fatal(err)
+    }
+    expected := `// Code generated by mustache; DO NOT EDIT.
+
+package views
+
+import "github.com/hoisie/mustache"
+
+var Templates = mustache.NewRegistry()
+
+func init() {
+	Templates.AddVersion("header", "<h1>{{title}}</h1>", "v1")
+	Templates.Add("page", "{{>header}}\n<p>{{body}}</p>")
+	Templates.AddVariant("aside", "b", "<aside>")
+	Templates.AddVariant("page", "b", "<div>{{body}}</div>")
+}
+`
+    if buf.String() != expected {
+        t.Fatalf("expected %q got %q", expected, buf.String())
+    }
+
+    if formatted, err := format.Source(buf.Bytes()); err != nil || string(formatted) != buf.String() {
+        t.Fatalf("expected gofmt'd source, got %v", err)
+    }
+
+    for _, ident := range [][2]string{{"views", "not valid"}, {"func", "Templates"}} {
+        if err := r.WriteGo(&buf, ident[0], ident[1]); err == nil {
+            t.Fatalf("expected an error for package %q and variable %q", ident[0], ident[1])
+        }
+    }
+}
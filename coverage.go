@@ -0,0 +1,115 @@
+package mustache
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+)
+
+// Coverage records which tags renders exercise, so that template tests can
+// report the variables, sections and partials they never reach. Pass it to
+// renders in RenderOptions.Coverage. A Coverage is safe for concurrent use.
+type Coverage struct {
+    mu   sync.Mutex
+    tags map[interface{}]uint8
+}
+
+const (
+    coverRendered uint8 = 1 << iota
+    coverSkipped
+)
+
+// NewCoverage returns an empty Coverage.
+func NewCoverage() *Coverage {
+    return &Coverage{tags: make(map[interface{}]uint8)}
+}
+
+func (c *Coverage) record(elem interface{}, bit uint8) {
+    c.mu.Lock()
+    c.tags[elem] |= bit
+    c.mu.Unlock()
+}
+
+// CoverageGap is a tag that the renders recorded by a Coverage did not fully
+// exercise. Partial names the partial the tag is in, or is empty for a tag
+// of the template itself. Tag is written with the default delimiters.
+type CoverageGap struct {
+    Partial string
+    Line    int
+    Column  int
+    Tag     string
+    Message string
+}
+
+func (g CoverageGap) String() string {
+    s := fmt.Sprintf("%d:%d: %s %s", g.Line, g.Column, g.Tag, g.Message)
+    if g.Partial != "" {
+        s = g.Partial + ":" + s
+    }
+    return s
+}
+
+// Uncovered reports the tags of tmpl that the recorded renders did not
+// fully exercise: variables and partials that were never rendered, and
+// sections that were never rendered or never skipped. Tags inside sections
+// and partials that were never rendered are not reported separately.
+// Partials resolved at render time are only checked for being included.
+func (c *Coverage) Uncovered(tmpl *Template) []CoverageGap {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    gaps := c.uncovered(tmpl.elems, "", nil, map[*Template]bool{tmpl: true})
+    sort.SliceStable(gaps, func(i, j int) bool {
+        a, b := gaps[i], gaps[j]
+        if a.Partial != b.Partial {
+            return a.Partial < b.Partial
+        }
+        if a.Line != b.Line {
+            return a.Line < b.Line
+        }
+        return a.Column < b.Column
+    })
+    return gaps
+}
+
+// uncovered appends the gaps in elems to gaps. seen holds the templates
+// being walked, since partials may include themselves in SpecStrict mode.
+func (c *Coverage) uncovered(elems []interface{}, partial string, gaps []CoverageGap, seen map[*Template]bool) []CoverageGap {
+    gap := func(p pos, tag diffNode, message string) {
+        gaps = append(gaps, CoverageGap{partial, p.line, p.col, tag.String(), message})
+    }
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *varElement:
+            if c.tags[elem]&coverRendered == 0 {
+                kind := byte('v')
+                if elem.raw {
+                    kind = '{'
+                }
+                gap(elem.pos, diffNode{kind: kind, value: elem.name}, "never rendered")
+            }
+        case *sectionElement:
+            tag := diffNode{kind: '#', value: elem.name}
+            if elem.inverted {
+                tag.kind = '^'
+            }
+            if c.tags[elem]&coverSkipped == 0 {
+                gap(elem.pos, tag, "never skipped")
+            }
+            if c.tags[elem]&coverRendered == 0 {
+                gap(elem.pos, tag, "never rendered")
+                continue
+            }
+            gaps = c.uncovered(elem.elems, partial, gaps, seen)
+        case *partialElement:
+            if c.tags[elem]&coverRendered == 0 {
+                gap(elem.pos, diffNode{kind: '>', value: elem.name}, "never rendered")
+                continue
+            }
+            if elem.tmpl != nil && !seen[elem.tmpl] {
+                seen[elem.tmpl] = true
+                gaps = c.uncovered(elem.tmpl.elems, elem.name, gaps, seen)
+            }
+        }
+    }
+    return gaps
+}
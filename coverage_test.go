@@ -0,0 +1,53 @@
+package mustache
+
+import (
+    "io/ioutil"
+    "testing"
+)
+
+func TestCoverage(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "item":   "{{name}}{{#sale}}!{{/sale}}",
+        "footer": "{{year}}",
+    }}
+    tmpl, err := ParseStringPartials("{{#items}}{{>item}}{{/items}}{{^items}}{{empty}}{{/items}}\n{{#admin}}{{>footer}}{{/admin}}{{{raw}}}", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    cov := NewCoverage()
+    render := func(context interface{}) {
+        if err := tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Coverage: cov}, context); err != nil {
+            t.Fatal(err)
+        }
+    }
+    render(map[string]interface{}{"items": []map[string]interface{}{{"name": "a"}}, "raw": 1})
+
+    check := func(expected []string) {
+        t.Helper()
+        gaps := cov.Uncovered(tmpl)
+        if len(gaps) != len(expected) {
+            t.Fatalf("expected %d gaps, got %v", len(expected), gaps)
+        }
+        for i, gap := range gaps {
+            if gap.String() != expected[i] {
+                t.Errorf("expected %q got %q", expected[i], gap.String())
+            }
+        }
+    }
+    check([]string{
+        "1:1: {{#items}} never skipped",
+        "1:30: {{^items}} never rendered",
+        "2:1: {{#admin}} never rendered",
+        "item:1:9: {{#sale}} never rendered",
+    })
+
+    render(map[string]interface{}{"admin": true, "items": []map[string]interface{}{{"sale": true}}})
+    check([]string{
+        "1:1: {{#items}} never skipped",
+        "1:30: {{^items}} never rendered",
+    })
+
+    render(nil)
+    check(nil)
+}
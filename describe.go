@@ -0,0 +1,64 @@
+package mustache
+
+import (
+    "bytes"
+    "strings"
+)
+
+// TagDoc describes a tag of a template, with the comments documenting it.
+type TagDoc struct {
+    // Kind is "variable", "section", "inverted" or "partial".
+    Kind   string `json:"kind"`
+    Name   string `json:"name"`
+    Line   int    `json:"line"`
+    Column int    `json:"column"`
+    // Doc is the text of the comment tags just before the tag, with
+    // nothing but whitespace in between, one per line.
+    Doc string `json:"doc,omitempty"`
+    // Tags describes the tags in a section.
+    Tags []TagDoc `json:"tags,omitempty"`
+}
+
+// Describe lists the tags of a template, in order, with the comments
+// documenting them, such as "{{! The signed-in user. }}{{name}}". It makes
+// docstrings of comments, which can be published to the people filling in
+// a template's data. Partials are described by name only, and need not
+// exist.
+func Describe(src string) ([]TagDoc, error) {
+    tmpl, err := ParseStringPartials(src, &lintProvider{nil, map[string]error{}})
+    if err != nil {
+        return nil, err
+    }
+    return describe(tmpl.elems), nil
+}
+
+func describe(elems []interface{}) []TagDoc {
+    var docs []TagDoc
+    var comments []string
+    for _, elem := range elems {
+        doc := TagDoc{Doc: strings.Join(comments, "\n")}
+        switch elem := elem.(type) {
+        case *commentElement:
+            comments = append(comments, elem.text)
+            continue
+        case *textElement:
+            if len(bytes.TrimSpace(elem.text)) > 0 {
+                comments = nil
+            }
+            continue
+        case *varElement:
+            doc.Kind, doc.Name, doc.Line, doc.Column = "variable", elem.name, elem.line, elem.col
+        case *sectionElement:
+            doc.Kind, doc.Name, doc.Line, doc.Column = "section", elem.name, elem.line, elem.col
+            if elem.inverted {
+                doc.Kind = "inverted"
+            }
+            doc.Tags = describe(elem.elems)
+        case *partialElement:
+            doc.Kind, doc.Name, doc.Line, doc.Column = "partial", elem.name, elem.line, elem.col
+        }
+        docs = append(docs, doc)
+        comments = nil
+    }
+    return docs
+}
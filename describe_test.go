@@ -0,0 +1,45 @@
+package mustache
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func TestDescribe(t *testing.T) {
+    src := `{{! Page title. }}
+{{title}}
+{{! The products on sale. }}
+{{! Empty outside of sales. }}
+{{#products}}
+  {{! Price in cents. }} {{price}} {{name}}
+{{/products}}
+{{! Orphaned, text follows. }}
+text
+{{^products}}{{>empty}}{{/products}}`
+    docs, err := Describe(src)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []TagDoc{
+        {Kind: "variable", Name: "title", Line: 2, Column: 1, Doc: "Page title."},
+        {Kind: "section", Name: "products", Line: 5, Column: 1, Doc: "The products on sale.\nEmpty outside of sales.", Tags: []TagDoc{
+            {Kind: "variable", Name: "price", Line: 6, Column: 26, Doc: "Price in cents."},
+            {Kind: "variable", Name: "name", Line: 6, Column: 36},
+        }},
+        {Kind: "inverted", Name: "products", Line: 10, Column: 1, Tags: []TagDoc{
+            {Kind: "partial", Name: "empty", Line: 10, Column: 14},
+        }},
+    }
+    if !reflect.DeepEqual(docs, expected) {
+        t.Fatalf("expected %+v got %+v", expected, docs)
+    }
+
+    data, _ := json.Marshal(docs[0])
+    if string(data) != `{"kind":"variable","name":"title","line":2,"column":1,"doc":"Page title."}` {
+        t.Fatalf("unexpected JSON %s", data)
+    }
+    if _, err := Describe(`{{#open}}`); err == nil {
+        t.Fatal("expected a parse error")
+    }
+}
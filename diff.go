@@ -0,0 +1,177 @@
+package mustache
+
+import (
+    "fmt"
+)
+
+// ChangeKind classifies a TemplateChange.
+type ChangeKind string
+
+const (
+    TagAdded    ChangeKind = "added"
+    TagRemoved  ChangeKind = "removed"
+    TagRenamed  ChangeKind = "renamed"
+    TextChanged ChangeKind = "text changed"
+)
+
+// TemplateChange is one structural difference between two templates. Old
+// and New hold the tag on either side, written with the default delimiters,
+// or the quoted text; one of them is empty for additions and removals. The
+// lines locate the change in each template and are 0 on the side it is
+// missing from.
+type TemplateChange struct {
+    Kind    ChangeKind
+    Old     string
+    New     string
+    OldLine int
+    NewLine int
+}
+
+func (c TemplateChange) String() string {
+    switch c.Kind {
+    case TagAdded:
+        return fmt.Sprintf("line %d: added %s", c.NewLine, c.New)
+    case TagRemoved:
+        return fmt.Sprintf("line %d: removed %s", c.OldLine, c.Old)
+    case TagRenamed:
+        return fmt.Sprintf("line %d: renamed %s to %s", c.NewLine, c.Old, c.New)
+    }
+    return fmt.Sprintf("line %d: changed %s to %s", c.NewLine, c.Old, c.New)
+}
+
+// diffNode is an element of a flattened template. Section close tags are
+// included so that sections align, but are never reported.
+type diffNode struct {
+    kind  byte // 0 for text, otherwise the tag sigil
+    value string
+    line  int
+}
+
+func (n diffNode) String() string {
+    switch n.kind {
+    case 0:
+        return fmt.Sprintf("%q", n.value)
+    case 'v':
+        return "{{" + n.value + "}}"
+    case '{':
+        return "{{{" + n.value + "}}}"
+    case '!':
+        return "{{! " + n.value + " }}"
+    }
+    return "{{" + string(n.kind) + n.value + "}}"
+}
+
+func flatten(elems []interface{}, nodes []diffNode) []diffNode {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *textElement:
+            if len(elem.text) > 0 {
+                nodes = append(nodes, diffNode{0, string(elem.text), elem.line})
+            }
+        case *varElement:
+            kind := byte('v')
+            if elem.raw {
+                kind = '{'
+            }
+            nodes = append(nodes, diffNode{kind, elem.tag(), elem.line})
+        case *commentElement:
+            nodes = append(nodes, diffNode{'!', elem.text, elem.line})
+        case *partialElement:
+            nodes = append(nodes, diffNode{'>', elem.name, elem.line})
+        case *sectionElement:
+            kind := byte('#')
+            if elem.inverted {
+                kind = '^'
+            }
+            nodes = append(nodes, diffNode{kind, elem.name, elem.line})
+            nodes = flatten(elem.elems, nodes)
+            nodes = append(nodes, diffNode{'/', elem.name, elem.line})
+        }
+    }
+    return nodes
+}
+
+// Diff compares two templates structurally, reporting tags that were added,
+// removed or renamed and text that changed, in the order they appear. Tags
+// are compared by kind and name, so differences in delimiters or in the
+// whitespace inside tags are not reported. Partials are compared by name.
+func Diff(old, new *Template) []TemplateChange {
+    a := flatten(old.elems, nil)
+    b := flatten(new.elems, nil)
+
+    // lcs[i][j] is the length of the longest common subsequence of a[i:]
+    // and b[j:]
+    lcs := make([][]int, len(a)+1)
+    for i := range lcs {
+        lcs[i] = make([]int, len(b)+1)
+    }
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i].kind == b[j].kind && a[i].value == b[j].value {
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            } else if lcs[i+1][j] >= lcs[i][j+1] {
+                lcs[i][j] = lcs[i+1][j]
+            } else {
+                lcs[i][j] = lcs[i][j+1]
+            }
+        }
+    }
+
+    var changes []TemplateChange
+    var removed, added []diffNode
+    i, j := 0, 0
+    for i < len(a) || j < len(b) {
+        switch {
+        case i < len(a) && j < len(b) && a[i].kind == b[j].kind && a[i].value == b[j].value:
+            changes = appendChanges(changes, removed, added)
+            removed, added = nil, nil
+            i++
+            j++
+        case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+            removed = append(removed, a[i])
+            i++
+        default:
+            added = append(added, b[j])
+            j++
+        }
+    }
+    return appendChanges(changes, removed, added)
+}
+
+// appendChanges reports a run of removed nodes replaced by a run of added
+// ones. Nodes of the same kind are paired up in order as renames or text
+// changes; the rest are plain removals and additions.
+func appendChanges(changes []TemplateChange, removed, added []diffNode) []TemplateChange {
+    paired := make([]bool, len(added))
+    for _, r := range removed {
+        match := -1
+        for k, a := range added {
+            if !paired[k] && a.kind == r.kind {
+                match = k
+                break
+            }
+        }
+        if r.kind == '/' {
+            if match >= 0 {
+                paired[match] = true
+            }
+            continue
+        }
+        if match < 0 {
+            changes = append(changes, TemplateChange{TagRemoved, r.String(), "", r.line, 0})
+            continue
+        }
+        paired[match] = true
+        kind := TagRenamed
+        if r.kind == 0 {
+            kind = TextChanged
+        }
+        changes = append(changes, TemplateChange{kind, r.String(), added[match].String(), r.line, added[match].line})
+    }
+    for k, a := range added {
+        if !paired[k] && a.kind != '/' {
+            changes = append(changes, TemplateChange{TagAdded, "", a.String(), 0, a.line})
+        }
+    }
+    return changes
+}
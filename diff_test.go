@@ -0,0 +1,50 @@
+package mustache
+
+import (
+    "testing"
+)
+
+type DiffTest struct {
+    old      string
+    new      string
+    expected []string
+}
+
+var diffTests = []DiffTest{
+    {`hello {{name}}`, `hello {{ name }}`, nil},
+    {`hello {{name}}`, `{{=<% %>=}}hello <%name%>`, nil},
+    {`hello {{name}}`, `hello {{user}}`, []string{"line 1: renamed {{name}} to {{user}}"}},
+    {`hello {{name}}`, `hello {{{name}}}`, []string{"line 1: removed {{name}}", "line 1: added {{{name}}}"}},
+    {`hello {{name}}`, `goodbye {{name}}`, []string{`line 1: changed "hello " to "goodbye "`}},
+    {`{{a}}`, "{{a}}\n{{#b}}{{c}}{{/b}}", []string{`line 1: added "\n"`, "line 2: added {{#b}}", "line 2: added {{c}}"}},
+    {"{{#b}}{{c}}{{/b}}{{a}}", `{{a}}`, []string{"line 1: removed {{#b}}", "line 1: removed {{c}}"}},
+    {`{{#a}}x{{/a}}`, `{{^a}}x{{/a}}`, []string{"line 1: removed {{#a}}", "line 1: added {{^a}}"}},
+    {`{{#a}}x{{/a}}`, `{{#b}}x{{/b}}`, []string{"line 1: renamed {{#a}} to {{#b}}"}},
+    {`{{! todo }}{{>header}}`, `{{! done }}{{>footer}}`, []string{"line 1: renamed {{! todo }} to {{! done }}", "line 1: renamed {{>header}} to {{>footer}}"}},
+}
+
+func TestDiff(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"header": "", "footer": ""}}
+    for _, test := range diffTests {
+        old, err := ParseStringPartials(test.old, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        new, err := ParseStringPartials(test.new, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var output []string
+        for _, change := range Diff(old, new) {
+            output = append(output, change.String())
+        }
+        if len(output) != len(test.expected) {
+            t.Fatalf("%q -> %q expected %q got %q", test.old, test.new, test.expected, output)
+        }
+        for i := range output {
+            if output[i] != test.expected[i] {
+                t.Fatalf("%q -> %q expected %q got %q", test.old, test.new, test.expected, output)
+            }
+        }
+    }
+}
@@ -0,0 +1,113 @@
+package mustache
+
+import "strings"
+
+// RenderExample renders the template with made-up data, so that its
+// structure can be reviewed before real data exists. Variables render as
+// their name in guillemets, as «name», and sections render once, for an
+// item holding the names used inside. Inverted sections for names used
+// only by inverted sections render too. Helpers are not called, unless
+// set with SetDefaults: a variable calling one renders as the whole tag in
+// guillemets, and a section calling one renders once. The data comes from
+// the template and the partials parsed with it.
+func (tmpl *Template) RenderExample() string {
+    data := map[string]interface{}{}
+    exampleData(tmpl.elems, data, map[*Template]bool{tmpl: true})
+    return tmpl.Render(data)
+}
+
+// exampleData adds to data the names used in elems. seen holds the
+// partials walked already, which may include themselves.
+func exampleData(elems []interface{}, data map[string]interface{}, seen map[*Template]bool) {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *varElement:
+            if elem.name != "." {
+                setExample(data, elem.name, "«"+elem.name+"»")
+            }
+        case *sectionElement:
+            if elem.inverted {
+                exampleData(elem.elems, data, seen)
+                continue
+            }
+            if existing, ok := exampleValue(data, elem.name).(map[string]interface{}); ok {
+                exampleData(elem.elems, existing, seen)
+                continue
+            }
+            item := map[string]interface{}{}
+            if list, ok := exampleValue(data, elem.name).([]interface{}); ok && len(list) == 1 {
+                if existing, ok := list[0].(map[string]interface{}); ok {
+                    item = existing
+                }
+            }
+            exampleData(elem.elems, item, seen)
+            if len(item) == 0 && usesDot(elem.elems) {
+                setExample(data, elem.name, []interface{}{"«" + elem.name + "»"})
+            } else {
+                setExample(data, elem.name, []interface{}{item})
+            }
+        case *partialElement:
+            if elem.tmpl != nil && !seen[elem.tmpl] {
+                seen[elem.tmpl] = true
+                exampleData(elem.tmpl.elems, data, seen)
+            }
+        }
+    }
+}
+
+// usesDot reports whether elems interpolate the implicit iterator.
+func usesDot(elems []interface{}) bool {
+    for _, elem := range elems {
+        if v, ok := elem.(*varElement); ok && v.name == "." {
+            return true
+        }
+    }
+    return false
+}
+
+// exampleValue returns the value at a dotted name in data, if any.
+func exampleValue(data map[string]interface{}, name string) interface{} {
+    if strings.Contains(name, " ") {
+        return data[name]
+    }
+    parts := strings.Split(name, ".")
+    for _, part := range parts[:len(parts)-1] {
+        next, ok := data[part].(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        data = next
+    }
+    return data[parts[len(parts)-1]]
+}
+
+// setExample sets the value at a dotted name in data, making maps along
+// the way. A name used both as a value and to hold others holds the others,
+// in a map rather than a list if it is also a section's.
+func setExample(data map[string]interface{}, name string, value interface{}) {
+    if strings.Contains(name, " ") {
+        // a helper's tag, looked up whole when no helper is found
+        data[name] = value
+        return
+    }
+    parts := strings.Split(name, ".")
+    for _, part := range parts[:len(parts)-1] {
+        if list, ok := data[part].([]interface{}); ok && len(list) == 1 {
+            // a section's single item, which renders the section once too
+            if item, ok := list[0].(map[string]interface{}); ok {
+                data[part] = item
+            }
+        }
+        next, ok := data[part].(map[string]interface{})
+        if !ok {
+            next = map[string]interface{}{}
+            data[part] = next
+        }
+        data = next
+    }
+    last := parts[len(parts)-1]
+    if _, ok := data[last].(map[string]interface{}); ok {
+        return
+    }
+    data[last] = value
+}
@@ -0,0 +1,29 @@
+package mustache
+
+import "testing"
+
+func TestRenderExample(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"card": "<b>{{title}}</b>{{#tags}}#{{.}} {{/tags}}"}}
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`Hi {{name}}, {{{html}}}`, "Hi «name», «html»"},
+        {`{{#items}}<li>{{name}}: {{price}}</li>{{/items}}`, "<li>«name»: «price»</li>"},
+        {`{{#items}}{{name}}{{/items}}{{^items}}none{{/items}}{{^empty}}no {{thing}}{{/empty}}`, "«name»no «thing»"},
+        {`{{user.name}} {{#user}}{{age}}{{/user}}`, "«user.name» «age»"},
+        {`{{>card}}`, "<b>«title»</b>#«tags» "},
+        {`{{#top5 products}}{{name}}{{/top5}} {{greet who}}`, "«name» «greet who»"},
+        {`{{#a}}{{#b}}{{c}}{{/b}}{{/a}}`, "«c»"},
+        {`{{#user}}{{age}}{{/user}} {{user.name}}`, "«age» «user.name»"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.tmpl, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if output := tmpl.RenderExample(); output != test.expected {
+            t.Errorf("%s: expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+}
@@ -0,0 +1,39 @@
+// Package expvarmetrics publishes the measurements of mustache templates
+// with the expvar package. It is kept out of the mustache package because
+// importing expvar links net/http and registers /debug/vars on
+// http.DefaultServeMux.
+package expvarmetrics
+
+import (
+    "expvar"
+    "time"
+)
+
+// Metrics is a mustache.Metrics publishing counters in an expvar.Map:
+// renders, render_errors and render_nanoseconds, the total time spent
+// rendering, and cache_hits and cache_misses.
+type Metrics struct {
+    Map *expvar.Map
+}
+
+// New returns a Metrics publishing its counters under name. Like
+// expvar.NewMap, it panics if name is already in use.
+func New(name string) *Metrics {
+    return &Metrics{expvar.NewMap(name)}
+}
+
+func (m *Metrics) Rendered(d time.Duration, err error) {
+    m.Map.Add("renders", 1)
+    m.Map.Add("render_nanoseconds", int64(d))
+    if err != nil {
+        m.Map.Add("render_errors", 1)
+    }
+}
+
+func (m *Metrics) CacheLookup(hit bool) {
+    if hit {
+        m.Map.Add("cache_hits", 1)
+    } else {
+        m.Map.Add("cache_misses", 1)
+    }
+}
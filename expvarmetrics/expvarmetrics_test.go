@@ -0,0 +1,26 @@
+package expvarmetrics
+
+import (
+    "errors"
+    "expvar"
+    "testing"
+    "time"
+)
+
+func TestMetrics(t *testing.T) {
+    m := New("mustache_test")
+    m.Rendered(time.Millisecond, nil)
+    m.Rendered(time.Second, errors.New("failed"))
+    m.CacheLookup(true)
+    m.CacheLookup(false)
+    m.CacheLookup(false)
+    expected := map[string]string{"renders": "2", "render_errors": "1", "cache_hits": "1", "cache_misses": "2"}
+    for key, value := range expected {
+        if got := m.Map.Get(key); got == nil || got.String() != value {
+            t.Fatalf("%s: expected %s got %v", key, value, got)
+        }
+    }
+    if expvar.Get("mustache_test") != m.Map {
+        t.Fatal("expected the map to be published")
+    }
+}
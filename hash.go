@@ -0,0 +1,87 @@
+package mustache
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+)
+
+// Hash returns a hash of the template's structure, which templates render
+// alike for have in common, so that a host of user templates can share one
+// compiled instance, and its caches, between identical templates. Comments
+// and set delimiter tags do not count, nor do the lines SpecStrict removes
+// around standalone tags, but the SpecStrict option does. Partials resolved
+// when parsing count by content, those resolved when rendering by name.
+// Settings made after parsing, such as SetEscape and SetDefaults, do not
+// count, so only templates given the same ones are interchangeable.
+func (tmpl *Template) Hash() string {
+    sum := sha256.Sum256(tmpl.canonical())
+    return hex.EncodeToString(sum[:])
+}
+
+// Equal reports whether the template has the same structure as other, as
+// Hash compares them.
+func (tmpl *Template) Equal(other *Template) bool {
+    return bytes.Equal(tmpl.canonical(), other.canonical())
+}
+
+// canonical encodes the structure of the template unambiguously.
+func (tmpl *Template) canonical() []byte {
+    var buf bytes.Buffer
+    if tmpl.opts.SpecStrict {
+        buf.WriteString("strict;")
+    }
+    canonicalElems(&buf, tmpl.elems, map[*Template]int{})
+    return buf.Bytes()
+}
+
+// canonicalElems encodes elems. seen numbers the partials encoded so far, so
+// that a partial including itself refers back to its encoding.
+func canonicalElems(buf *bytes.Buffer, elems []interface{}, seen map[*Template]int) {
+    // text either side of a comment is one run
+    var text []byte
+    flush := func() {
+        if len(text) > 0 {
+            fmt.Fprintf(buf, "t%d:%s", len(text), text)
+            text = nil
+        }
+    }
+    for _, elem := range elems {
+        kind := byte('v')
+        switch elem := elem.(type) {
+        case *textElement:
+            text = append(text, elem.text...)
+        case *varElement:
+            if elem.raw {
+                kind = '&'
+            }
+            flush()
+            fmt.Fprintf(buf, "%c%d:%s", kind, len(elem.tag()), elem.tag())
+        case *sectionElement:
+            kind = '#'
+            if elem.inverted {
+                kind = '^'
+            }
+            flush()
+            fmt.Fprintf(buf, "%c%d:%s", kind, len(elem.name), elem.name)
+            canonicalElems(buf, elem.elems, seen)
+            buf.WriteByte('/')
+        case *partialElement:
+            flush()
+            fmt.Fprintf(buf, ">%d:%s%d:%s", len(elem.name), elem.name, len(elem.indent), elem.indent)
+            if elem.tmpl == nil {
+                break
+            }
+            if n, ok := seen[elem.tmpl]; ok {
+                fmt.Fprintf(buf, "@%d;", n)
+                break
+            }
+            seen[elem.tmpl] = len(seen)
+            buf.WriteByte('{')
+            canonicalElems(buf, elem.tmpl.elems, seen)
+            buf.WriteByte('}')
+        }
+    }
+    flush()
+}
@@ -0,0 +1,56 @@
+package mustache
+
+import "testing"
+
+func TestHash(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"p": "{{x}}"}}
+    tests := []struct {
+        a, b  string
+        equal bool
+    }{
+        {`Hi {{name}}`, `Hi {{ name }}`, true},
+        {`Hi {{! greeting }}{{name}}`, `Hi {{name}}`, true},
+        {`Hi{{! a }}there`, `Hi there`, false},
+        {`H{{! a }}i`, `Hi`, true},
+        {`{{=<% %>=}}<%#a%><%b%><%/a%>`, `{{#a}}{{b}}{{/a}}`, true},
+        {`{{{a}}}`, `{{&a}}`, true},
+        {`{{{a}}}`, `{{a}}`, false},
+        {`{{#a}}{{/a}}`, `{{^a}}{{/a}}`, false},
+        {`{{#a}}b{{/a}}`, `{{#a}}{{/a}}b`, false},
+        {`{{>p}}`, `{{> p }}`, true},
+        {`{{a}}1`, `{{a1}}`, false},
+    }
+    for _, test := range tests {
+        a, err := ParseStringPartials(test.a, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        b, err := ParseStringPartials(test.b, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if a.Equal(b) != test.equal || (a.Hash() == b.Hash()) != test.equal {
+            t.Errorf("%s and %s: expected equal to be %v", test.a, test.b, test.equal)
+        }
+    }
+
+    // partials count by content
+    other := &StaticProvider{map[string]string{"p": "{{y}}"}}
+    a, _ := ParseStringPartials(`{{>p}}`, partials)
+    b, _ := ParseStringPartials(`{{>p}}`, other)
+    if a.Equal(b) || a.Hash() == b.Hash() {
+        t.Error("expected the content of partials to count")
+    }
+    recursive := &StaticProvider{map[string]string{"p": "{{#c}}{{>p}}{{/c}}"}}
+    a, _ = ParseStringOptions(`{{>p}}`, ParseOptions{Partials: recursive, SpecStrict: true})
+    b, _ = ParseStringOptions(`{{>p}}`, ParseOptions{Partials: recursive, SpecStrict: true})
+    if !a.Equal(b) {
+        t.Error("expected recursive partials to compare equal")
+    }
+
+    plain, _ := ParseString("{{#a}}\nb\n{{/a}}\n")
+    strict, _ := ParseStringOptions("{{#a}}\nb\n{{/a}}\n", ParseOptions{SpecStrict: true})
+    if plain.Equal(strict) {
+        t.Error("expected the SpecStrict option to count")
+    }
+}
@@ -0,0 +1,229 @@
+package mustache
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+)
+
+// A helper is a function that a tag calls by name, passing the values of
+// the names that follow it, as in {{#top5 products}}. The result is
+// rendered in place of the tag's value. Helpers are usually supplied to
+// every render with Template.SetDefaults; a function in the data is only
+// called by a tag giving it arguments, so that one named alone renders as
+// any other value.
+
+// SortBy returns a helper that returns a sorted copy of a slice or array,
+// ordering its items by the named field, map key or method. A leading "-"
+// sorts in descending order. Numbers compare numerically and other values
+// by their formatted text; the sort is stable.
+func SortBy(field string) func(list interface{}) interface{} {
+    desc := len(field) > 0 && field[0] == '-'
+    if desc {
+        field = field[1:]
+    }
+    return func(list interface{}) interface{} {
+        v := indirect(reflect.ValueOf(list))
+        if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+            return list
+        }
+        r := newRenderer(nil, &defaultRenderOptions)
+        keys := make([]reflect.Value, v.Len())
+        order := make([]int, v.Len())
+        for i := range keys {
+            keys[i] = indirect(r.lookup([]reflect.Value{v.Index(i)}, field))
+            order[i] = i
+        }
+        sort.SliceStable(order, func(i, j int) bool {
+            if desc {
+                return less(keys[order[j]], keys[order[i]])
+            }
+            return less(keys[order[i]], keys[order[j]])
+        })
+
+        sorted := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+        for i, j := range order {
+            sorted.Index(i).Set(v.Index(j))
+        }
+        return sorted.Interface()
+    }
+}
+
+// Limit returns a helper that returns at most the first n items of a slice
+// or array.
+func Limit(n int) func(list interface{}) interface{} {
+    if n < 0 {
+        n = 0
+    }
+    return func(list interface{}) interface{} {
+        v := indirect(reflect.ValueOf(list))
+        if v.Kind() != reflect.Slice && v.Kind() != reflect.Array || v.Len() <= n {
+            return list
+        }
+        if v.Kind() == reflect.Array {
+            // arrays that are not addressable cannot be sliced
+            limited := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), n, n)
+            reflect.Copy(limited, v)
+            return limited.Interface()
+        }
+        return v.Slice(0, n).Interface()
+    }
+}
+
+// relativeUnits are the units RelativeTime counts in, largest first. Months
+// and years are approximate.
+var relativeUnits = []struct {
+    name string
+    d    time.Duration
+}{
+    {"year", 365 * 24 * time.Hour},
+    {"month", 30 * 24 * time.Hour},
+    {"day", 24 * time.Hour},
+    {"hour", time.Hour},
+    {"minute", time.Minute},
+}
+
+// RelativeTime returns a helper rendering a time.Time relative to the time
+// of the render, as given by Now: "3 days ago", "in 2 hours", or "just now"
+// within a minute. The time is counted in the largest unit it spans of
+// year, month, day, hour and minute, rounding down. words, if not nil,
+// phrases the count instead, for other languages: it is given the count,
+// negative for times past, and the unit's name, or 0 and "second" within a
+// minute.
+func RelativeTime(words func(n int, unit string) string) func(ctx context.Context, t time.Time) string {
+    if words == nil {
+        words = englishRelativeTime
+    }
+    return func(ctx context.Context, t time.Time) string {
+        d := t.Sub(Now(ctx))
+        abs := d
+        if abs < 0 {
+            abs = -abs
+        }
+        for _, unit := range relativeUnits {
+            if abs >= unit.d {
+                n := int(abs / unit.d)
+                if d < 0 {
+                    n = -n
+                }
+                return words(n, unit.name)
+            }
+        }
+        return words(0, "second")
+    }
+}
+
+func englishRelativeTime(n int, unit string) string {
+    if n == 0 {
+        return "just now"
+    }
+    count := n
+    if count < 0 {
+        count = -count
+    }
+    phrase := fmt.Sprintf("%d %s", count, unit)
+    if count != 1 {
+        phrase += "s"
+    }
+    if n < 0 {
+        return phrase + " ago"
+    }
+    return "in " + phrase
+}
+
+// AddDate returns a helper that adds the given years, months and days to a
+// time.Time, as time.Time.AddDate does, for sections such as
+// {{#due30 invoiced}}{{date .}}{{/due30}}.
+func AddDate(years, months, days int) func(t time.Time) time.Time {
+    return func(t time.Time) time.Time {
+        return t.AddDate(years, months, days)
+    }
+}
+
+// FormatTime returns a helper that formats a time.Time with the given
+// layout, as time.Time.Format does.
+func FormatTime(layout string) func(t time.Time) string {
+    return func(t time.Time) string {
+        return t.Format(layout)
+    }
+}
+
+// less orders the sort keys of two items. Missing keys sort first.
+func less(a, b reflect.Value) bool {
+    if !a.IsValid() || !b.IsValid() {
+        return !a.IsValid() && b.IsValid()
+    }
+    switch {
+    case isInt(a) && isInt(b):
+        return a.Int() < b.Int()
+    case isNumber(a) && isNumber(b):
+        return toFloat(a) < toFloat(b)
+    }
+    return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
+
+func isInt(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return true
+    }
+    return false
+}
+
+func isNumber(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+        reflect.Float32, reflect.Float64:
+        return true
+    }
+    return isInt(v)
+}
+
+func toFloat(v reflect.Value) float64 {
+    switch v.Kind() {
+    case reflect.Float32, reflect.Float64:
+        return v.Float()
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return float64(v.Uint())
+    }
+    return float64(v.Int())
+}
+
+// URLSection is a section value that builds a URL, escaping the values
+// interpolated in the section's body for the part of the URL they are in:
+// path segments with url.PathEscape, query keys and values with
+// url.QueryEscape, and the fragment like a path segment. With "url" set to
+// URLSection{} in the defaults, {{#url}}/search?q={{query}}{{/url}} renders
+// a query of "a&b=c" as /search?q=a%26b%3Dc. The URL is then escaped as
+// the template escapes values, so the body's text must not be escaped
+// already: write & rather than &amp;. Values in triple braces are left as
+// they are, for a base URL to start from.
+type URLSection struct{}
+
+var urlSectionType = reflect.TypeOf(URLSection{})
+
+// ClassSection is a section value that renders a list of CSS classes from
+// flags in the data. The section's body lists the classes, separated by
+// commas, each followed by a colon and the name of its flag, or alone to be
+// always listed. With "classes" set to ClassSection{} in the defaults,
+// class="{{#classes}}item, active:is_active, disabled:is_disabled{{/classes}}"
+// renders class="item active" when is_active is truthy and is_disabled is
+// not. Flags are truthy as sections are.
+type ClassSection struct{}
+
+var classSectionType = reflect.TypeOf(ClassSection{})
+
+// escapeURLPart escapes s to follow prefix, the start of a URL.
+func escapeURLPart(prefix, s string) string {
+    switch {
+    case strings.Contains(prefix, "#"):
+        return url.PathEscape(s)
+    case strings.Contains(prefix, "?"):
+        return url.QueryEscape(s)
+    }
+    return url.PathEscape(s)
+}
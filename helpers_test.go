@@ -0,0 +1,193 @@
+package mustache
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+type product struct {
+    Name  string
+    Price float64
+}
+
+func (p product) Label() string { return strings.ToUpper(p.Name) }
+
+func TestHelpers(t *testing.T) {
+    products := []product{{"tea", 4}, {"cake", 3.5}, {"pie", 6}, {"jam", 2}}
+    helpers := map[string]interface{}{
+        "byPrice": SortBy("Price"),
+        "byName":  SortBy("-Label"),
+        "byN":     SortBy("n"),
+        "top2":    Limit(2),
+        "greet":   func(name string) string { return "Hello, " + name },
+        "now":     func() string { return "noon" },
+        "fail":    func() (string, error) { return "", errors.New("no data") },
+    }
+    context := map[string]interface{}{
+        "products": products,
+        "ranks":    [3]map[string]interface{}{{"n": 3}, {"n": 10}, {"n": 1}},
+        "join":     func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+        "sep":      "/",
+        "a":        "x",
+        "b":        "y",
+        "who":      "<b>",
+    }
+    render := func(data string) string {
+        tmpl, err := ParseString(data)
+        if err != nil {
+            return err.Error()
+        }
+        tmpl.SetDefaults(helpers)
+        return tmpl.Render(context)
+    }
+
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{#byPrice products}}{{Name}} {{/byPrice}}`, "jam cake tea pie "},
+        {`{{#byName products}}{{Name}} {{/byName}}`, "tea pie jam cake "},
+        {`{{#top2 products}}{{Name}} {{/top2}}`, "tea cake "},
+        {`{{#top2 ranks}}{{n}} {{/top2 ranks}}`, "3 10 "},
+        {`{{#byN ranks}}{{n}} {{/byN}}`, "1 3 10 "},
+        {`{{^top2 missing}}none{{/top2}}`, "none"},
+        {`{{greet who}} {{{greet who}}}`, "Hello, &lt;b&gt; Hello, <b>"},
+        {`{{greet missing}}`, "Hello, "},
+        {`{{join sep a b}}|{{join sep}}`, "x/y|"},
+        {`{{now}}`, "noon"},
+        {`{{products.0}}`, ""},
+    }
+    for _, test := range tests {
+        if output := render(test.tmpl); output != test.expected {
+            t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+
+    errors := []struct {
+        tmpl     string
+        expected string
+    }{
+        {"\n{{fail}}", "line 2: calling fail: no data"},
+        {`{{greet}}`, "line 1: calling greet: expected 1 arguments, got 0"},
+        {`{{#greet products}}{{/greet}}`, "line 1: calling greet: cannot use products ([]mustache.product) as string"},
+    }
+    for _, test := range errors {
+        if output := render(test.tmpl); output != test.expected {
+            t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+
+    // functions in the data are only called when given arguments
+    data := map[string]interface{}{"upper": strings.ToUpper, "who": "<b>"}
+    if output := Render(`{{#upper}}x{{/upper}}{{^upper}}y{{/upper}}|{{upper who}}`, data); output != "x|&lt;B&gt;" {
+        t.Fatalf("unexpected output %q", output)
+    }
+    if output := Render(`{{upper}}`, data); strings.Contains(output, "calling") {
+        t.Fatalf("expected the function not to be called, got %q", output)
+    }
+
+    // names with spaces that do not call a helper are still looked up
+    if output := Render(`{{first name}}`, map[string]string{"first name": "Ann"}); output != "Ann" {
+        t.Fatalf("unexpected output %q", output)
+    }
+    if products[0].Name != "tea" {
+        t.Fatal("expected SortBy not to modify its input")
+    }
+}
+
+func TestURLSection(t *testing.T) {
+    context := map[string]interface{}{
+        "url":   URLSection{},
+        "query": "a&b=c d",
+        "user":  "jo/ann",
+        "base":  "https://example.com/x?",
+        "tags":  []string{"go", "c++"},
+    }
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{#url}}/search?q={{query}}{{/url}}`, "/search?q=a%26b%3Dc+d"},
+        {`<a href="{{#url}}/users/{{user}}?from={{user}}&q={{query}}#{{query}}{{/url}}">`,
+            `<a href="/users/jo%2Fann?from=jo%2Fann&amp;q=a%26b%3Dc+d#a&amp;b=c%20d">`},
+        {`{{#url}}{{{base}}}{{#tags}}t={{.}}&{{/tags}}{{/url}}`, "https://example.com/x?t=go&amp;t=c%2B%2B&amp;"},
+        {`{{#url}}/{{user}}{{/url}} {{user}}`, "/jo%2Fann jo/ann"},
+        {`{{^url}}none{{/url}}`, ""},
+    }
+    for _, test := range tests {
+        if output := Render(test.tmpl, context); output != test.expected {
+            t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+}
+
+func TestTimeHelpers(t *testing.T) {
+    now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+    context := map[string]interface{}{
+        "ago":      RelativeTime(nil),
+        "fr":       RelativeTime(func(n int, unit string) string { return fmt.Sprintf("%d %s", n, unit) }),
+        "due30":    AddDate(0, 0, 30),
+        "date":     FormatTime("2006-01-02"),
+        "recent":   now.Add(-30 * time.Second),
+        "minute":   now.Add(-time.Minute),
+        "hours":    now.Add(-5*time.Hour - 59*time.Minute),
+        "tomorrow": now.Add(24 * time.Hour),
+        "weeks":    now.Add(-20 * 24 * time.Hour),
+        "months":   now.Add(90 * 24 * time.Hour),
+        "years":    now.Add(-800 * 24 * time.Hour),
+    }
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{ago recent}}`, "just now"},
+        {`{{ago minute}}`, "1 minute ago"},
+        {`{{ago hours}}`, "5 hours ago"},
+        {`{{ago tomorrow}}`, "in 1 day"},
+        {`{{ago weeks}}`, "20 days ago"},
+        {`{{ago months}}`, "in 3 months"},
+        {`{{ago years}}`, "2 years ago"},
+        {`{{fr hours}}|{{fr recent}}`, "-5 hour|0 second"},
+        {`{{date tomorrow}}`, "2024-03-11"},
+        {`{{#due30 tomorrow}}{{date .}}{{/due30}}`, "2024-04-10"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseString(test.tmpl)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var sb strings.Builder
+        err = tmpl.FRenderOptions(&sb, RenderOptions{Now: func() time.Time { return now }}, context)
+        if err != nil || sb.String() != test.expected {
+            t.Errorf("%q expected %q got %q, %v", test.tmpl, test.expected, sb.String(), err)
+        }
+    }
+}
+
+func TestClassSection(t *testing.T) {
+    context := map[string]interface{}{
+        "classes":     ClassSection{},
+        "is_active":   true,
+        "is_disabled": false,
+        "items":       []string{"x"},
+        "none":        []string{},
+        "kind":        "card",
+    }
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`class="{{#classes}}active:is_active, disabled:is_disabled{{/classes}}"`, `class="active"`},
+        {`{{#classes}}item, active: is_active ,full:items,empty:none,{{/classes}}`, "item active full"},
+        {`{{#classes}}{{kind}}, {{kind}}-on:is_active, missing:nowhere{{/classes}}`, "card card-on"},
+        {`[{{#classes}}disabled:is_disabled{{/classes}}]`, "[]"},
+    }
+    for _, test := range tests {
+        if output := Render(test.tmpl, context); output != test.expected {
+            t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+}
@@ -0,0 +1,98 @@
+package mustache
+
+// Flatten returns a copy of the template with its partials inlined, so
+// that rendering it looks up no partials and Unparse writes it as a single
+// self-contained source. Standalone partials keep their indentation. The
+// partials parsed with the template are inlined as they are; those resolved
+// at render time, through a ContextPartialProvider, are fetched from
+// partials, or kept as partial tags if partials is nil. A partial including
+// itself is kept as a partial tag where it does. Render errors in inlined
+// partials are not wrapped in PartialErrors, as they are in the template.
+func (tmpl *Template) Flatten(partials PartialProvider) (*Template, error) {
+    elems, err := inline(tmpl.elems, partials, nil)
+    if err != nil {
+        return nil, err
+    }
+    flat := *tmpl
+    flat.elems = elems
+    flat.static = memoize(elems)
+    flat.included = nil
+    return &flat, nil
+}
+
+// inline returns a copy of elems with partials inlined. chain holds the
+// names of the partials being inlined, to find those including themselves.
+func inline(elems []interface{}, partials PartialProvider, chain []string) ([]interface{}, error) {
+    var out []interface{}
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *textElement:
+            text := *elem
+            out = appendText(out, &text)
+        case *sectionElement:
+            se := *elem
+            se.static = nil
+            var err error
+            if se.elems, err = inline(elem.elems, partials, chain); err != nil {
+                return nil, err
+            }
+            out = append(out, &se)
+        case *partialElement:
+            partial, err := inlinedPartial(elem, partials, chain)
+            if err != nil {
+                return nil, err
+            }
+            if partial == nil {
+                out = append(out, elem)
+                break
+            }
+            inlined, err := inline(partial.elems, partials, append(chain, elem.name))
+            if err != nil {
+                return nil, &PartialError{elem.name, elem.line, err}
+            }
+            for _, e := range inlined {
+                if text, ok := e.(*textElement); ok {
+                    out = appendText(out, text)
+                } else {
+                    out = append(out, e)
+                }
+            }
+        default:
+            out = append(out, elem)
+        }
+    }
+    return out, nil
+}
+
+// inlinedPartial returns the template of the partial to inline in place of
+// elem, or nil to keep elem.
+func inlinedPartial(elem *partialElement, partials PartialProvider, chain []string) (*Template, error) {
+    for _, name := range chain {
+        if name == elem.name {
+            return nil, nil
+        }
+    }
+    if len(chain) >= elem.opts.MaxDepth {
+        return nil, &LimitError{elem.line, "nesting depth", elem.opts.MaxDepth}
+    }
+    if elem.tmpl != nil || partials == nil {
+        return elem.tmpl, nil
+    }
+    opts := *elem.opts
+    opts.Partials = partials
+    data, err := partials.Get(opts.partialName(elem.name))
+    if _, ok := err.(*PartialNotFoundError); ok && opts.SpecStrict {
+        data, err = "", nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    partial := newTemplate([]byte(indentLines(data, elem.indent)), opts)
+    if opts.SpecStrict {
+        partial.included = make(map[string]*Template)
+    }
+    if err = partial.parse(); err != nil {
+        return nil, &PartialError{elem.name, elem.line, err}
+    }
+    return partial, nil
+}
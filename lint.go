@@ -0,0 +1,205 @@
+package mustache
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// LintRule identifies the kind of problem a LintFinding reports.
+type LintRule string
+
+const (
+    // LintSyntax reports a template that does not parse, such as an
+    // unclosed or interleaved section.
+    LintSyntax LintRule = "syntax"
+    // LintUnknownPartial reports a partial the provider cannot supply.
+    LintUnknownPartial LintRule = "unknown-partial"
+    // LintUnreachable reports a section nested in an opposite section of
+    // the same name, which therefore never renders.
+    LintUnreachable LintRule = "unreachable"
+    // LintShadowed reports a tag whose value is hidden by a key the layout
+    // machinery puts in front of the data.
+    LintShadowed LintRule = "shadowed"
+    // LintUnknownSigil reports a variable tag whose name starts with a
+    // character names cannot start with, most likely a mistyped sigil.
+    LintUnknownSigil LintRule = "unknown-sigil"
+)
+
+// LintFinding is a single problem reported by Lint. Column is 0 when only
+// the line is known.
+type LintFinding struct {
+    Rule    LintRule
+    Line    int
+    Column  int
+    Message string
+}
+
+func (f LintFinding) String() string {
+    return fmt.Sprintf("%d:%d: %s: %s", f.Line, f.Column, f.Rule, f.Message)
+}
+
+// LintOptions configures Lint.
+type LintOptions struct {
+    // Partials, if set, is used to check that every referenced partial
+    // exists. Otherwise partials are not checked.
+    Partials PartialProvider
+    // Layout reports that the template is used as a layout, so the keys in
+    // LayoutKeys shadow any data of the same name.
+    Layout bool
+    // LayoutKeys lists the keys injected when rendering a layout. It
+    // defaults to "content", the key used by RenderInLayout.
+    LayoutKeys []string
+}
+
+// lintProvider records partials its underlying provider cannot supply and
+// hands back empty templates for them, so that parsing can carry on.
+type lintProvider struct {
+    partials PartialProvider
+    missing  map[string]error
+}
+
+func (lp *lintProvider) Get(name string) (string, error) {
+    if lp.partials == nil {
+        return "", nil
+    }
+    data, err := lp.partials.Get(name)
+    if err != nil {
+        lp.missing[name] = err
+        return "", nil
+    }
+    return data, nil
+}
+
+// Lint checks a template for problems and returns them ordered by position.
+// A template that does not parse yields a single LintSyntax finding.
+func Lint(src string, opts LintOptions) []LintFinding {
+    lp := &lintProvider{opts.Partials, map[string]error{}}
+    tmpl, err := ParseStringPartials(src, lp)
+    if err != nil {
+        finding := LintFinding{Rule: LintSyntax, Message: err.Error()}
+        switch perr := err.(type) {
+        case parseError:
+            finding.Line = perr.line
+            finding.Message = perr.message
+        case *PartialError:
+            finding.Line = perr.Line
+        }
+        return []LintFinding{finding}
+    }
+
+    l := linter{opts: opts, missing: lp.missing}
+    if opts.Layout {
+        l.shadowing = opts.LayoutKeys
+        if l.shadowing == nil {
+            l.shadowing = []string{"content"}
+        }
+    }
+    l.walk(tmpl.elems, nil)
+    sort.Stable(byPosition(l.findings))
+    return l.findings
+}
+
+type linter struct {
+    opts      LintOptions
+    missing   map[string]error
+    shadowing []string
+    findings  []LintFinding
+}
+
+func (l *linter) report(rule LintRule, p pos, format string, args ...interface{}) {
+    l.findings = append(l.findings, LintFinding{rule, p.line, p.col, fmt.Sprintf(format, args...)})
+}
+
+// walk lints elems. sections holds the enclosing sections, innermost last.
+// Partials are linted on their own, so walk only descends into them for the
+// partials they include.
+func (l *linter) walk(elems []interface{}, sections []*sectionElement) {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *varElement:
+            if c := unknownSigil(elem.name); c != 0 && !elem.raw {
+                l.report(LintUnknownSigil, elem.pos, "unknown sigil %q in tag %q", c, elem.name)
+            }
+            if len(sections) == 0 && elem.name != "." && !strings.Contains(elem.name, ".") {
+                // a plain top-level tag is how a layout reads its keys
+                break
+            }
+            l.checkShadowed(elem.name, elem.pos)
+        case *sectionElement:
+            l.checkShadowed(elem.name, elem.pos)
+            l.checkReachable(elem, sections)
+            l.walk(elem.elems, append(sections, elem))
+        case *partialElement:
+            if err, ok := l.missing[elem.name]; ok {
+                l.report(LintUnknownPartial, elem.pos, "%s", err)
+            } else if elem.tmpl != nil {
+                l.checkNested(elem, elem.name, elem.tmpl.elems, map[*Template]bool{elem.tmpl: true})
+            }
+        }
+    }
+}
+
+// checkNested reports the missing partials included by the partial called
+// name, directly or not, at the position of the tag including it.
+func (l *linter) checkNested(tag *partialElement, name string, elems []interface{}, seen map[*Template]bool) {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *sectionElement:
+            l.checkNested(tag, name, elem.elems, seen)
+        case *partialElement:
+            if err, ok := l.missing[elem.name]; ok {
+                l.report(LintUnknownPartial, tag.pos, "%s, in partial %q", err, name)
+            } else if elem.tmpl != nil && !seen[elem.tmpl] {
+                seen[elem.tmpl] = true
+                l.checkNested(tag, elem.name, elem.tmpl.elems, seen)
+            }
+        }
+    }
+}
+
+func (l *linter) checkShadowed(name string, p pos) {
+    first := strings.SplitN(name, ".", 2)[0]
+    for _, key := range l.shadowing {
+        if first == key {
+            l.report(LintShadowed, p, "%q resolves to the layout's %q key, not to data", name, key)
+        }
+    }
+}
+
+// checkReachable reports a section whose nearest enclosing section of the
+// same name is its opposite. Only inverted sections may lie in between,
+// since any other section pushes a context that could change the lookup.
+func (l *linter) checkReachable(section *sectionElement, sections []*sectionElement) {
+    for i := len(sections) - 1; i >= 0; i-- {
+        outer := sections[i]
+        if outer.name == section.name {
+            if outer.inverted != section.inverted {
+                l.report(LintUnreachable, section.pos, "section %q can never render inside the %s section on line %d",
+                    section.name, sectionKind(outer), outer.line)
+            }
+            return
+        }
+        if !outer.inverted {
+            return
+        }
+    }
+}
+
+func sectionKind(section *sectionElement) string {
+    if section.inverted {
+        return "inverted"
+    }
+    return "normal"
+}
+
+type byPosition []LintFinding
+
+func (f byPosition) Len() int      { return len(f) }
+func (f byPosition) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byPosition) Less(i, j int) bool {
+    if f[i].Line != f[j].Line {
+        return f[i].Line < f[j].Line
+    }
+    return f[i].Column < f[j].Column
+}
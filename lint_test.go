@@ -0,0 +1,70 @@
+package mustache
+
+import (
+    "testing"
+)
+
+type LintTest struct {
+    tmpl     string
+    opts     LintOptions
+    expected []string
+}
+
+var lintPartials = &StaticProvider{map[string]string{
+    "header": "{{title}}",
+    "page":   "{{>header}}{{#items}}{{>row}}{{/items}}",
+    "row":    "{{>cell}}",
+}}
+
+var lintTests = []LintTest{
+    {`{{#a}}{{b}}{{/a}}`, LintOptions{}, nil},
+    {`{{#a}}{{b}}`, LintOptions{}, []string{"1:0: syntax: Section a has no closing tag"}},
+    {"\n{{#a}}{{#b}}{{/a}}{{/b}}", LintOptions{}, []string{"2:0: syntax: interleaved closing tag: a"}},
+    {`{{/a}}`, LintOptions{}, []string{"1:0: syntax: unmatched close tag"}},
+
+    //partials are only checked against a provider
+    {`{{>header}}{{>footer}}`, LintOptions{}, nil},
+    {`{{>header}} {{>footer}}`, LintOptions{Partials: lintPartials}, []string{`1:13: unknown-partial: Could not find partial "footer"`}},
+    {"{{>header}}\n{{>page}}", LintOptions{Partials: lintPartials}, []string{`2:1: unknown-partial: Could not find partial "cell", in partial "row"`}},
+
+    //unreachable sections
+    {`{{#a}}{{^a}}x{{/a}}{{/a}}`, LintOptions{}, []string{`1:7: unreachable: section "a" can never render inside the normal section on line 1`}},
+    {"{{^a}}\n{{^b}}{{#a}}x{{/a}}{{/b}}{{/a}}", LintOptions{}, []string{`2:7: unreachable: section "a" can never render inside the inverted section on line 1`}},
+    {`{{#a}}{{#b}}{{^a}}x{{/a}}{{/b}}{{/a}}`, LintOptions{}, nil},
+    {`{{#a}}{{#a}}x{{/a}}{{/a}}`, LintOptions{}, nil},
+    {`{{#a}}x{{/a}}{{^a}}y{{/a}}`, LintOptions{}, nil},
+
+    //unknown sigils
+    {`{{@index}} {{%name}} {{{<b}}} {{_id}} {{.}} {{9}} {{été}}`, LintOptions{}, []string{
+        `1:1: unknown-sigil: unknown sigil '@' in tag "@index"`,
+        `1:12: unknown-sigil: unknown sigil '%' in tag "%name"`,
+    }},
+
+    //layout keys
+    {`{{content}} {{#content}}{{/content}}`, LintOptions{}, nil},
+    {`{{{content}}} {{#content}}{{/content}}{{content.title}}`, LintOptions{Layout: true}, []string{
+        `1:15: shadowed: "content" resolves to the layout's "content" key, not to data`,
+        `1:39: shadowed: "content.title" resolves to the layout's "content" key, not to data`,
+    }},
+    {`{{body}} {{#page}}{{body}}{{/page}}`, LintOptions{Layout: true, LayoutKeys: []string{"body"}}, []string{
+        `1:19: shadowed: "body" resolves to the layout's "body" key, not to data`,
+    }},
+}
+
+func TestLint(t *testing.T) {
+    for _, test := range lintTests {
+        findings := Lint(test.tmpl, test.opts)
+        var output []string
+        for _, f := range findings {
+            output = append(output, f.String())
+        }
+        if len(output) != len(test.expected) {
+            t.Fatalf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+        for i := range output {
+            if output[i] != test.expected[i] {
+                t.Fatalf("%q expected %q got %q", test.tmpl, test.expected, output)
+            }
+        }
+    }
+}
@@ -0,0 +1,54 @@
+package mustache
+
+import (
+    "fmt"
+    "reflect"
+)
+
+// MergeData merges src into dst, for layering context data such as site
+// configuration and per-page overrides. Values in src replace those in dst,
+// except that maps present in both are merged recursively. Nested maps of
+// the map[interface{}]interface{} shape produced by YAML decoders are
+// converted to map[string]interface{} along the way. Maps from src are
+// copied, so later changes to dst do not modify src.
+func MergeData(dst, src map[string]interface{}) {
+    for key, value := range src {
+        srcMap, ok := stringMap(value)
+        if !ok {
+            dst[key] = value
+            continue
+        }
+        dstMap, ok := stringMap(dst[key])
+        if !ok {
+            dstMap = make(map[string]interface{}, len(srcMap))
+        }
+        MergeData(dstMap, srcMap)
+        dst[key] = dstMap
+    }
+}
+
+// stringMap returns value as a map[string]interface{}, converting a
+// map[interface{}]interface{} or another map with string keys, such as a
+// map[string]string.
+func stringMap(value interface{}) (map[string]interface{}, bool) {
+    switch m := value.(type) {
+    case map[string]interface{}:
+        return m, true
+    case map[interface{}]interface{}:
+        converted := make(map[string]interface{}, len(m))
+        for key, value := range m {
+            converted[fmt.Sprint(key)] = value
+        }
+        return converted, true
+    }
+    v := reflect.ValueOf(value)
+    if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+        return nil, false
+    }
+    converted := make(map[string]interface{}, v.Len())
+    iter := v.MapRange()
+    for iter.Next() {
+        converted[iter.Key().String()] = iter.Value().Interface()
+    }
+    return converted, true
+}
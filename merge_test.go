@@ -0,0 +1,44 @@
+package mustache
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestMergeData(t *testing.T) {
+    dst := map[string]interface{}{
+        "site":  map[string]interface{}{"name": "Example", "theme": "light"},
+        "year":  2024,
+        "links": []string{"home"},
+    }
+    src := map[string]interface{}{
+        "site":  map[interface{}]interface{}{"theme": "dark", "nav": map[interface{}]interface{}{1: "one"}},
+        "links": []string{"about"},
+        "draft": true,
+    }
+    MergeData(dst, src)
+
+    expected := map[string]interface{}{
+        "site": map[string]interface{}{
+            "name":  "Example",
+            "theme": "dark",
+            "nav":   map[string]interface{}{"1": "one"},
+        },
+        "year":  2024,
+        "links": []string{"about"},
+        "draft": true,
+    }
+    if !reflect.DeepEqual(dst, expected) {
+        t.Fatalf("expected %v got %v", expected, dst)
+    }
+
+    // maps from src are copied
+    dst["site"].(map[string]interface{})["theme"] = "blue"
+    if src["site"].(map[interface{}]interface{})["theme"] != "dark" {
+        t.Fatal("expected src not to be modified")
+    }
+
+    if output := Render("{{site.name}} {{site.nav.1}}", dst); output != "Example one" {
+        t.Fatalf("unexpected output %q", output)
+    }
+}
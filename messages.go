@@ -0,0 +1,73 @@
+package mustache
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// Message is a translatable message found by ExtractMessages.
+type Message struct {
+    Key  string `json:"key"`
+    File string `json:"file,omitempty"`
+    Line int    `json:"line"`
+}
+
+// ExtractMessages finds the keys of the messages a template translates,
+// which are the arguments of the tags calling the translation helper, as in
+// {{t greeting}} or {{#t cart.count}}...{{/t}} for a helper named t. file
+// is only recorded in the messages. Partials are not searched, since they
+// are extracted from their own files.
+func ExtractMessages(file, src, helper string) ([]Message, error) {
+    tmpl, err := ParseStringPartials(src, &lintProvider{nil, map[string]error{}})
+    if err != nil {
+        return nil, err
+    }
+    return extractMessages(tmpl.elems, file, helper, nil), nil
+}
+
+func extractMessages(elems []interface{}, file, helper string, messages []Message) []Message {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *varElement:
+            messages = appendMessage(messages, elem.name, file, elem.line, helper)
+        case *sectionElement:
+            messages = appendMessage(messages, elem.name, file, elem.line, helper)
+            messages = extractMessages(elem.elems, file, helper, messages)
+        }
+    }
+    return messages
+}
+
+// appendMessage appends a message to messages if the tag called name calls
+// helper.
+func appendMessage(messages []Message, name, file string, line int, helper string) []Message {
+    if helperName(name) != helper || len(name) == len(helper) {
+        return messages
+    }
+    key := strings.Join(strings.Fields(name)[1:], " ")
+    return append(messages, Message{key, file, line})
+}
+
+// WritePOT writes messages as a gettext template, listing each key once
+// with the places it is used, in the order keys first appear.
+func WritePOT(w io.Writer, messages []Message) error {
+    var keys []string
+    refs := map[string][]string{}
+    for _, m := range messages {
+        if _, ok := refs[m.Key]; !ok {
+            keys = append(keys, m.Key)
+        }
+        refs[m.Key] = append(refs[m.Key], fmt.Sprintf("%s:%d", m.File, m.Line))
+    }
+    bw := bufio.NewWriter(w)
+    for i, key := range keys {
+        if i > 0 {
+            bw.WriteString("\n")
+        }
+        fmt.Fprintf(bw, "#: %s\nmsgid %s\nmsgstr \"\"\n", strings.Join(refs[key], " "), strconv.Quote(key))
+    }
+    return bw.Flush()
+}
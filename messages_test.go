@@ -0,0 +1,51 @@
+package mustache
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+)
+
+func TestExtractMessages(t *testing.T) {
+    src := `<h1>{{t title}}</h1>
+{{#items}}{{t item.label}}{{/items}}
+{{#t cart.count}}{{n}}{{/t}}
+{{t}} {{title}} {{{t title}}}`
+    messages, err := ExtractMessages("page.mustache", src, "t")
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []Message{
+        {"title", "page.mustache", 1},
+        {"item.label", "page.mustache", 2},
+        {"cart.count", "page.mustache", 3},
+        {"title", "page.mustache", 4},
+    }
+    if !reflect.DeepEqual(messages, expected) {
+        t.Fatalf("expected %v got %v", expected, messages)
+    }
+
+    var buf bytes.Buffer
+    if err := WritePOT(&buf, messages); err != nil {
+        t.Fatal(err)
+    }
+    pot := `#: page.mustache:1 page.mustache:4
+msgid "title"
+msgstr ""
+
+#: page.mustache:2
+msgid "item.label"
+msgstr ""
+
+#: page.mustache:3
+msgid "cart.count"
+msgstr ""
+`
+    if buf.String() != pot {
+        t.Fatalf("expected %q got %q", pot, buf.String())
+    }
+
+    if _, err := ExtractMessages("bad.mustache", `{{#t x}}`, "t"); err == nil {
+        t.Fatal("expected a parse error")
+    }
+}
@@ -0,0 +1,23 @@
+package mustache
+
+import "time"
+
+// Metrics receives measurements of template use, so that they can be fed to
+// a monitoring system. Its methods may be called from several goroutines at
+// once. The expvarmetrics subpackage publishes them with the expvar
+// package; for another system, such as Prometheus, implement Metrics with
+// its counters and histograms.
+type Metrics interface {
+    // Rendered is called after each render of a template with the time it
+    // took and the error it failed with, if any.
+    Rendered(d time.Duration, err error)
+    // CacheLookup is called when a Registry looks for a compiled template,
+    // reporting whether it found one.
+    CacheLookup(hit bool)
+}
+
+// SetMetrics sets the Metrics that every render of the template reports to.
+// Renders of its partials are part of the render including them.
+func (tmpl *Template) SetMetrics(metrics Metrics) {
+    tmpl.metrics = metrics
+}
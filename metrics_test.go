@@ -0,0 +1,62 @@
+package mustache
+
+import (
+    "errors"
+    "sync"
+    "testing"
+    "time"
+)
+
+type recordingMetrics struct {
+    mu      sync.Mutex
+    renders int
+    errors  int
+    hits    int
+    misses  int
+}
+
+func (m *recordingMetrics) Rendered(d time.Duration, err error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.renders++
+    if err != nil {
+        m.errors++
+    }
+}
+
+func (m *recordingMetrics) CacheLookup(hit bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if hit {
+        m.hits++
+    } else {
+        m.misses++
+    }
+}
+
+func TestMetrics(t *testing.T) {
+    m := &recordingMetrics{}
+    static, _ := ParseString(`static`)
+    static.SetMetrics(m)
+    failing, _ := ParseString(`{{fail}}`)
+    failing.SetMetrics(m)
+    failing.SetDefaults(map[string]interface{}{"fail": func() (string, error) { return "", errors.New("failed") }})
+    static.Render()
+    static.Render()
+    failing.Render()
+    if m.renders != 3 || m.errors != 1 {
+        t.Fatalf("expected 3 renders and 1 error, got %d and %d", m.renders, m.errors)
+    }
+
+    m = &recordingMetrics{}
+    r := NewRegistry()
+    r.Metrics = m
+    r.Add("page", "{{>header}}")
+    r.Add("header", "hi")
+    r.Render("page")
+    r.Render("page")
+    r.Render("missing")
+    if m.hits != 1 || m.misses != 1 || m.renders != 2 {
+        t.Fatalf("expected 1 hit, 1 miss and 2 renders, got %d, %d and %d", m.hits, m.misses, m.renders)
+    }
+}
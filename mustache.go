@@ -1,42 +1,108 @@
 package mustache
 
 import (
+    "bufio"
     "bytes"
-    "errors"
+    "context"
+    "encoding/json"
     "fmt"
-    "html/template"
     "io"
     "io/ioutil"
     "os"
-    "path"
+    "path/filepath"
     "reflect"
+    "regexp"
+    "runtime/debug"
+    "strconv"
     "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
 )
 
+// pos is the position of an element in the template source. Columns are counted
+// in runes, starting at 1.
+type pos struct {
+    line int
+    col  int
+}
+
+func (p pos) position() pos { return p }
+
 type textElement struct {
     text []byte
+    pos
 }
 
 type varElement struct {
-    name string
-    raw  bool
+    name   string
+    raw    bool
+    format string // the fmt verb formatting the value, if any
+    pos
+}
+
+// tag returns the text of the tag, without braces or sigils.
+func (v *varElement) tag() string {
+    if v.format == "" {
+        return v.name
+    }
+    return v.name + ":" + v.format
 }
 
 type sectionElement struct {
-    name      string
-    inverted  bool
-    startline int
-    elems     []interface{}
+    name     string
+    inverted bool
+    elems    []interface{}
+    static   []byte // the output of elems, if it cannot depend on the data
+    pos
+}
+
+// partialElement includes a partial. tmpl is nil if the partial is
+// resolved at render time, with the options of the including template.
+type partialElement struct {
+    name   string
+    tmpl   *Template
+    opts   *ParseOptions
+    indent string // prefixed to each line of a standalone partial
+    pos
+}
+
+type commentElement struct {
+    text string
+    pos
+}
+
+// marker returns the name of the marker the comment sets, as in {{!@head}},
+// if it is one.
+func (c *commentElement) marker() (string, bool) {
+    if !strings.HasPrefix(c.text, "@") {
+        return "", false
+    }
+    return strings.TrimSpace(c.text[1:]), true
 }
 
 type Template struct {
-    data    string
-    otag    string
-    ctag    string
-    p       int
-    curline int
-    dir     string
-    elems   []interface{}
+    data      []byte
+    otag      string
+    ctag      string
+    p         int
+    curline   int
+    linestart int
+    coloffset int
+    col       int
+    opts      ParseOptions
+    depth     int
+    nesting   int // the deepest nesting of sections, including partials
+    elems     []interface{}
+    static    []byte // the output, if it cannot depend on the data
+    defaults  reflect.Value
+    escape    func(string) string
+    metrics   Metrics
+    included  map[string]*Template // partials parsed so far, in SpecStrict mode
+    arena     *arena               // allocates the elements, with ParseOptions.Arena
+    names     map[string]string    // interned tags and names, shared with partials
+    filename  string
+    fileinfo  os.FileInfo
 }
 
 type parseError struct {
@@ -46,250 +112,532 @@ type parseError struct {
 
 func (p parseError) Error() string { return fmt.Sprintf("line %d: %s", p.line, p.message) }
 
-var (
-    esc_quot = []byte("&quot;")
-    esc_apos = []byte("&apos;")
-    esc_amp  = []byte("&amp;")
-    esc_lt   = []byte("&lt;")
-    esc_gt   = []byte("&gt;")
-)
+// PartialError reports an error inside a partial. Line is the line of the
+// tag including the partial; Err may itself be a PartialError when the
+// error is in a partial included by that one.
+type PartialError struct {
+    Name string
+    Line int
+    Err  error
+}
 
-func (tmpl *Template) readString(s string) (string, error) {
-    i := tmpl.p
-    newlines := 0
-    for true {
-        //are we at the end of the string?
-        if i+len(s) > len(tmpl.data) {
-            return tmpl.data[tmpl.p:], io.EOF
+// Error describes the error followed by the chain of inclusions leading to
+// it, innermost first.
+func (e *PartialError) Error() string {
+    var stack []*PartialError
+    var err error = e
+    for {
+        pe, ok := err.(*PartialError)
+        if !ok {
+            break
         }
+        stack = append(stack, pe)
+        err = pe.Err
+    }
 
-        if tmpl.data[i] == '\n' {
-            newlines++
+    msg := fmt.Sprintf("%s, in partial %q", err, stack[len(stack)-1].Name)
+    for i := len(stack) - 1; i > 0; {
+        // collapse the repeated inclusions of a recursive partial
+        n := 1
+        for i-n > 0 && stack[i-n].Name == stack[i].Name && stack[i-n].Line == stack[i].Line &&
+            stack[i-n-1].Name == stack[i-1].Name {
+            n++
         }
-
-        if tmpl.data[i] != s[0] {
-            i++
-            continue
+        msg += fmt.Sprintf(" included from partial %q on line %d", stack[i-1].Name, stack[i].Line)
+        if n > 1 {
+            msg += fmt.Sprintf(" (%d times)", n)
         }
+        msg += ","
+        i -= n
+    }
+    return msg + fmt.Sprintf(" included from the template on line %d", stack[0].Line)
+}
 
-        match := true
-        for j := 1; j < len(s); j++ {
-            if s[j] != tmpl.data[i+j] {
-                match = false
-                break
-            }
-        }
+func (e *PartialError) Unwrap() error { return e.Err }
 
-        if match {
-            e := i + len(s)
-            text := tmpl.data[tmpl.p:e]
-            tmpl.p = e
+// LimitError is returned when a template exceeds one of the limits set in
+// ParseOptions.
+type LimitError struct {
+    Line  int
+    Limit string
+    Max   int
+}
 
-            tmpl.curline += newlines
-            return text, nil
-        } else {
-            i++
-        }
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("line %d: %s exceeds the limit of %d", e.Line, e.Limit, e.Max)
+}
+
+var (
+    newline = []byte("\n")
+    crlf    = []byte("\r\n")
+)
+
+// readTo reads up to and including s. If s does not occur, it returns the
+// rest of the data and io.EOF.
+func (tmpl *Template) readTo(s string) ([]byte, error) {
+    rest := tmpl.data[tmpl.p:]
+    i := bytes.Index(rest, []byte(s))
+    if i < 0 {
+        return rest, io.EOF
     }
 
-    //should never be here
-    return "", nil
+    if n := bytes.Count(rest[:i], newline); n > 0 {
+        tmpl.curline += n
+        tmpl.linestart = tmpl.p + bytes.LastIndex(rest[:i], newline) + 1
+    }
+    tmpl.p += i + len(s)
+    return rest[:i+len(s)], nil
 }
 
-func (tmpl *Template) parsePartial(name string) (*Template, error) {
-    filenames := []string{
-        path.Join(tmpl.dir, name),
-        path.Join(tmpl.dir, name+".mustache"),
-        path.Join(tmpl.dir, name+".stache"),
-        name,
-        name + ".mustache",
-        name + ".stache",
+// pos returns the position of the byte at offset, which must be on the
+// current line and no earlier than the offset of the previous call. Columns
+// are counted on from the previous call so that long lines stay linear.
+func (tmpl *Template) pos(offset int) pos {
+    if tmpl.coloffset < tmpl.linestart {
+        tmpl.coloffset, tmpl.col = tmpl.linestart, 1
     }
-    var filename string
-    for _, name := range filenames {
-        f, err := os.Open(name)
-        if err == nil {
-            filename = name
-            f.Close()
-            break
+    tmpl.col += utf8.RuneCount(tmpl.data[tmpl.coloffset:offset])
+    tmpl.coloffset = offset
+    return pos{tmpl.curline, tmpl.col}
+}
+
+// skipNewline advances past a line ending of n bytes.
+func (tmpl *Template) skipNewline(n int) {
+    tmpl.p += n
+    tmpl.curline++
+    tmpl.linestart = tmpl.p
+}
+
+// setDelimiters handles a set delimiter tag such as {{=<% %>=}}. The new
+// delimiters may be separated by any run of whitespace, and neither may be
+// empty, contain an equals sign or be invalid UTF-8. Delimiters are matched
+// as whole strings, so multi-byte delimiters such as « » work unchanged.
+func (tmpl *Template) setDelimiters(tag string) error {
+    if len(tag) < 2 || tag[len(tag)-1] != '=' {
+        return parseError{tmpl.curline, "Invalid meta tag"}
+    }
+    newtags := strings.Fields(tag[1 : len(tag)-1])
+    if len(newtags) != 2 {
+        return parseError{tmpl.curline, "Invalid meta tag: expected two delimiters"}
+    }
+    for _, t := range newtags {
+        if strings.Contains(t, "=") || !utf8.ValidString(t) {
+            return parseError{tmpl.curline, fmt.Sprintf("Invalid delimiter %q", t)}
         }
     }
-    if filename == "" {
-        return nil, errors.New(fmt.Sprintf("Could not find partial %q", name))
+    for _, t := range newtags {
+        if len(t) > tmpl.opts.MaxDelimiterLength {
+            return &LimitError{tmpl.curline, "delimiter length", tmpl.opts.MaxDelimiterLength}
+        }
     }
+    tmpl.otag = newtags[0]
+    tmpl.ctag = newtags[1]
+    return nil
+}
 
-    partial, err := ParseFile(filename)
-
+func (tmpl *Template) parsePartial(name string, line int, indent string) (*Template, error) {
+    data, opts, err := tmpl.opts.readPartial(tmpl.opts.partialName(name))
+    if _, ok := err.(*PartialNotFoundError); ok && tmpl.opts.SpecStrict {
+        data, err = "", nil
+    }
     if err != nil {
         return nil, err
     }
 
+    partial := newTemplate([]byte(indentLines(data, indent)), opts)
+    partial.depth = tmpl.depth
+    partial.arena = tmpl.arena
+    partial.names = tmpl.names
+    if tmpl.included != nil {
+        // register the partial before parsing it, so that a partial
+        // including itself refers back to it rather than recursing
+        partial.included = tmpl.included
+        tmpl.included[tmpl.includedKey(name, indent)] = partial
+    }
+    err = partial.parse()
+    if err != nil {
+        return nil, &PartialError{name, line, err}
+    }
+
     return partial, nil
 }
 
-func (tmpl *Template) parseSection(section *sectionElement) error {
-    for {
-        text, err := tmpl.readString(tmpl.otag)
+// includedKey returns the key in tmpl.included of the partial called name,
+// included with the given indentation. The names a FileProvider resolves
+// depend on the directory it searches first, so the key includes it.
+func (tmpl *Template) includedKey(name, indent string) string {
+    key := indent + ">" + name
+    if fp, ok := tmpl.opts.Partials.(*FileProvider); ok && len(fp.Paths) > 0 {
+        key = fp.Paths[0] + "\x00" + key
+    }
+    return key
+}
 
-        if err == io.EOF {
-            return parseError{section.startline, "Section " + section.name + " has no closing tag"}
-        }
+func (tmpl *Template) parse() error {
+    elems, err := tmpl.parseBlock(nil)
+    if err != nil {
+        return err
+    }
+    if tmpl.opts.Minify {
+        elems = minify(elems)
+    }
+    tmpl.elems = elems
+    tmpl.static = memoize(elems)
+    return nil
+}
 
-        // put text into an item
-        text = text[0 : len(text)-len(tmpl.otag)]
-        section.elems = append(section.elems, &textElement{[]byte(text)})
-        if tmpl.p < len(tmpl.data) && tmpl.data[tmpl.p] == '{' {
-            text, err = tmpl.readString("}" + tmpl.ctag)
-        } else {
-            text, err = tmpl.readString(tmpl.ctag)
+// memoize returns the output of elems if it cannot depend on the data, or
+// nil, caching the output of any static section bodies along the way.
+func memoize(elems []interface{}) []byte {
+    static := []byte{}
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *textElement:
+            if static != nil {
+                static = append(static, elem.text...)
+            }
+        case *commentElement:
+        case *sectionElement:
+            elem.static = memoize(elem.elems)
+            static = nil
+        case *partialElement:
+            if elem.tmpl != nil && elem.tmpl.static != nil && static != nil {
+                static = append(static, elem.tmpl.static...)
+            } else {
+                static = nil
+            }
+        default:
+            static = nil
         }
+    }
+    return static
+}
 
-        if err == io.EOF {
-            //put the remaining text in a block
-            return parseError{tmpl.curline, "unmatched open tag"}
+// indentLines prefixes each line of data with indent.
+func indentLines(data string, indent string) string {
+    if indent == "" || data == "" {
+        return data
+    }
+    lines := strings.SplitAfter(data, "\n")
+    if lines[len(lines)-1] == "" {
+        lines = lines[:len(lines)-1]
+    }
+    return indent + strings.Join(lines, indent)
+}
+
+// isBlank reports whether b holds only spaces and tabs.
+func isBlank(b []byte) bool {
+    for _, c := range b {
+        if c != ' ' && c != '\t' {
+            return false
         }
+    }
+    return true
+}
 
-        //trim the close tag off the text
-        tag := strings.TrimSpace(text[0 : len(text)-len(tmpl.ctag)])
+// standalone handles a tag that, in SpecStrict mode, stands alone on its
+// line: the whitespace before it is dropped from elems and returned, and
+// the rest of the line, up to and including the line ending, is skipped.
+// tagstart is the offset of the tag's opening delimiter, and tmpl.p is just
+// past its closing one.
+func (tmpl *Template) standalone(tagstart int, elems []interface{}) (string, []interface{}, bool) {
+    linestart := bytes.LastIndexByte(tmpl.data[:tagstart], '\n') + 1
+    indent := tmpl.data[linestart:tagstart]
+    if !isBlank(indent) {
+        return "", elems, false
+    }
+    rest := tmpl.data[tmpl.p:]
+    end := bytes.IndexByte(rest, '\n')
+    if end < 0 {
+        end = len(rest)
+    }
+    if !isBlank(bytes.TrimSuffix(rest[:end], []byte("\r"))) {
+        return "", elems, false
+    }
 
-        if len(tag) == 0 {
-            return parseError{tmpl.curline, "empty tag"}
+    if len(indent) > 0 {
+        // the indentation is the tail of the text just before the tag
+        last := elems[len(elems)-1].(*textElement)
+        last.text = last.text[:len(last.text)-len(indent)]
+        if len(last.text) == 0 {
+            elems = elems[:len(elems)-1]
         }
-        switch tag[0] {
-        case '!':
-            //ignore comment
-            break
-        case '#', '^':
-            name := strings.TrimSpace(tag[1:])
+    }
+    if end < len(rest) {
+        tmpl.p += end
+        tmpl.skipNewline(1)
+    } else {
+        tmpl.p += end
+    }
+    return string(indent), elems, true
+}
 
-            //ignore the newline when a section starts
-            if len(tmpl.data) > tmpl.p && tmpl.data[tmpl.p] == '\n' {
-                tmpl.p += 1
-            } else if len(tmpl.data) > tmpl.p+1 && tmpl.data[tmpl.p] == '\r' && tmpl.data[tmpl.p+1] == '\n' {
-                tmpl.p += 2
-            }
+// asset returns the text an asset tag inserts.
+func (tmpl *Template) asset(name string, p pos) ([]byte, error) {
+    data, err := tmpl.opts.Assets.Get(name)
+    if err != nil {
+        return nil, parseError{p.line, fmt.Sprintf("asset %s: %v", name, err)}
+    }
+    if tmpl.opts.EscapeAsset != nil {
+        data = tmpl.opts.EscapeAsset(name, data)
+    }
+    return []byte(data), nil
+}
 
-            se := sectionElement{name, tag[0] == '^', tmpl.curline, []interface{}{}}
-            err := tmpl.parseSection(&se)
-            if err != nil {
-                return err
-            }
-            section.elems = append(section.elems, &se)
-        case '/':
-            name := strings.TrimSpace(tag[1:])
-            if name != section.name {
-                return parseError{tmpl.curline, "interleaved closing tag: " + name}
-            } else {
-                return nil
-            }
-        case '>':
-            name := strings.TrimSpace(tag[1:])
-            partial, err := tmpl.parsePartial(name)
-            if err != nil {
-                return err
-            }
-            section.elems = append(section.elems, partial)
-        case '=':
-            if tag[len(tag)-1] != '=' {
-                return parseError{tmpl.curline, "Invalid meta tag"}
-            }
-            tag = strings.TrimSpace(tag[1 : len(tag)-1])
-            newtags := strings.SplitN(tag, " ", 2)
-            if len(newtags) == 2 {
-                tmpl.otag = newtags[0]
-                tmpl.ctag = newtags[1]
+// variable returns the element for a variable tag. tag is the tag's text,
+// without braces or sigils, and raw is true if it renders unescaped.
+func (tmpl *Template) variable(tag string, raw bool, p pos) *varElement {
+    name, format := tag, ""
+    if i := strings.Index(tag, ":%"); i >= 0 && tmpl.opts.FormatVerbs {
+        name, format = strings.TrimSpace(tag[:i]), tag[i+1:]
+    }
+    if !raw && tmpl.opts.Raw != nil {
+        raw = tmpl.opts.Raw(name)
+    }
+    return tmpl.arena.variable(varElement{tmpl.intern(name), raw, format, p})
+}
+
+// unknownSigil returns the first character of a variable tag's name if a
+// name cannot start with it, as with {{@name}} or {{%name}}, or else 0.
+// Such a character is most likely a mistyped sigil.
+func unknownSigil(name string) rune {
+    c, _ := utf8.DecodeRuneInString(name)
+    if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.' {
+        return 0
+    }
+    return c
+}
+
+// malformedRaw reports a raw tag at p lacking its closing brace. tag is the
+// tag's text between the delimiters, as far as it goes.
+func (tmpl *Template) malformedRaw(p pos, tag string) error {
+    return parseError{p.line, fmt.Sprintf("malformed raw tag %s at column %d: missing closing brace", tmpl.otag+tag+tmpl.ctag, p.col)}
+}
+
+// intern returns the string equal to s in the template's table of names,
+// adding s if there is none, so that a name used by many tags is stored
+// once and, being the same string, compares quickly.
+func (tmpl *Template) intern(s string) string {
+    if name, ok := tmpl.names[s]; ok {
+        return name
+    }
+    tmpl.names[s] = s
+    return s
+}
+
+// internBytes is intern for the bytes of a tag, which are only copied to a
+// string the first time they are seen.
+func (tmpl *Template) internBytes(b []byte) string {
+    if name, ok := tmpl.names[string(b)]; ok {
+        return name
+    }
+    s := string(b)
+    tmpl.names[s] = s
+    return s
+}
+
+// appendText appends text to elems, merging it into a preceding text element
+// so that rendering issues fewer writes. Empty text is dropped.
+func appendText(elems []interface{}, text *textElement) []interface{} {
+    if len(text.text) == 0 {
+        return elems
+    }
+    if n := len(elems); n > 0 {
+        if last, ok := elems[n-1].(*textElement); ok {
+            // copy, since text elements may share the template source
+            merged := make([]byte, 0, len(last.text)+len(text.text))
+            last.text = append(append(merged, last.text...), text.text...)
+            return elems
+        }
+    }
+    return append(elems, text)
+}
+
+// minify drops comments other than markers from elems and collapses each run of whitespace-only
+// text to a single newline, if the run contains one, or a single space.
+func minify(elems []interface{}) []interface{} {
+    var out []interface{}
+    var space *textElement
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *commentElement:
+            if _, ok := elem.marker(); !ok {
+                continue
             }
-        case '{':
-            if tag[len(tag)-1] == '}' {
-                //use a raw tag
-                section.elems = append(section.elems, &varElement{tag[1 : len(tag)-1], true})
+        case *textElement:
+            if len(bytes.TrimSpace(elem.text)) == 0 {
+                if space == nil {
+                    space = &textElement{[]byte(" "), elem.pos}
+                }
+                if bytes.IndexByte(elem.text, '\n') >= 0 {
+                    space.text = []byte("\n")
+                }
+                continue
             }
-        default:
-            section.elems = append(section.elems, &varElement{tag, false})
+        case *sectionElement:
+            elem.elems = minify(elem.elems)
+        }
+        if space != nil {
+            out = appendText(out, space)
+            space = nil
+        }
+        if text, ok := elem.(*textElement); ok {
+            out = appendText(out, text)
+        } else {
+            out = append(out, elem)
         }
     }
-
-    return nil
+    if space != nil {
+        out = appendText(out, space)
+    }
+    return out
 }
 
-func (tmpl *Template) parse() error {
+// parseBlock parses elements up to the end of the template or, when section
+// is not nil, up to the section's closing tag.
+func (tmpl *Template) parseBlock(section *sectionElement) ([]interface{}, error) {
+    elems := []interface{}{}
     for {
-        text, err := tmpl.readString(tmpl.otag)
+        textpos := tmpl.pos(tmpl.p)
+        text, err := tmpl.readTo(tmpl.otag)
         if err == io.EOF {
+            if section != nil {
+                return nil, parseError{section.line, "Section " + section.name + " has no closing tag"}
+            }
             //put the remaining text in a block
-            tmpl.elems = append(tmpl.elems, &textElement{[]byte(text)})
-            return nil
+            return appendText(elems, tmpl.arena.text(textElement{text, textpos})), nil
         }
 
         // put text into an item
         text = text[0 : len(text)-len(tmpl.otag)]
-        tmpl.elems = append(tmpl.elems, &textElement{[]byte(text)})
+        elems = appendText(elems, tmpl.arena.text(textElement{text, textpos}))
+        tagstart := tmpl.p - len(tmpl.otag)
+        tagpos := tmpl.pos(tagstart)
 
-        if tmpl.p < len(tmpl.data) && tmpl.data[tmpl.p] == '{' {
-            text, err = tmpl.readString("}" + tmpl.ctag)
+        raw := tmpl.p < len(tmpl.data) && tmpl.data[tmpl.p] == '{'
+        if raw {
+            text, err = tmpl.readTo("}" + tmpl.ctag)
         } else {
-            text, err = tmpl.readString(tmpl.ctag)
+            text, err = tmpl.readTo(tmpl.ctag)
         }
 
         if err == io.EOF {
-            //put the remaining text in a block
-            return parseError{tmpl.curline, "unmatched open tag"}
+            if i := bytes.Index(text, []byte(tmpl.ctag)); raw && i >= 0 {
+                return nil, tmpl.malformedRaw(tagpos, string(text[:i]))
+            }
+            return nil, parseError{tmpl.curline, "unmatched open tag"}
+        }
+        if len(text) > tmpl.opts.MaxTagLength {
+            return nil, &LimitError{tagpos.line, "tag length", tmpl.opts.MaxTagLength}
         }
 
         //trim the close tag off the text
-        tag := strings.TrimSpace(text[0 : len(text)-len(tmpl.ctag)])
+        tag := tmpl.internBytes(bytes.TrimSpace(text[0 : len(text)-len(tmpl.ctag)]))
         if len(tag) == 0 {
-            return parseError{tmpl.curline, "empty tag"}
+            return nil, parseError{tmpl.curline, "empty tag"}
+        }
+        var indent string
+        standalone := false
+        if tmpl.opts.SpecStrict && strings.IndexByte("!#^/>=", tag[0]) >= 0 {
+            indent, elems, standalone = tmpl.standalone(tagstart, elems)
         }
         switch tag[0] {
         case '!':
-            //ignore comment
-            break
+            elems = append(elems, tmpl.arena.comment(commentElement{strings.TrimSpace(tag[1:]), tagpos}))
         case '#', '^':
-            name := strings.TrimSpace(tag[1:])
+            name := tmpl.intern(strings.TrimSpace(tag[1:]))
 
-            if len(tmpl.data) > tmpl.p && tmpl.data[tmpl.p] == '\n' {
-                tmpl.p += 1
-            } else if len(tmpl.data) > tmpl.p+1 && tmpl.data[tmpl.p] == '\r' && tmpl.data[tmpl.p+1] == '\n' {
-                tmpl.p += 2
+            //ignore the newline when a section starts
+            if tmpl.opts.SpecStrict {
+                // standalone lines are handled above
+            } else if bytes.HasPrefix(tmpl.data[tmpl.p:], newline) {
+                tmpl.skipNewline(1)
+            } else if bytes.HasPrefix(tmpl.data[tmpl.p:], crlf) {
+                tmpl.skipNewline(2)
             }
 
-            se := sectionElement{name, tag[0] == '^', tmpl.curline, []interface{}{}}
-            err := tmpl.parseSection(&se)
+            if tmpl.depth >= tmpl.opts.MaxDepth {
+                return nil, &LimitError{tmpl.curline, "nesting depth", tmpl.opts.MaxDepth}
+            }
+            se := tmpl.arena.section(sectionElement{name: name, inverted: tag[0] == '^', pos: tagpos})
+            tmpl.depth++
+            if tmpl.depth > tmpl.nesting {
+                tmpl.nesting = tmpl.depth
+            }
+            se.elems, err = tmpl.parseBlock(se)
+            tmpl.depth--
             if err != nil {
-                return err
+                return nil, err
             }
-            tmpl.elems = append(tmpl.elems, &se)
+            elems = append(elems, se)
         case '/':
-            return parseError{tmpl.curline, "unmatched close tag"}
+            name := strings.TrimSpace(tag[1:])
+            if section == nil {
+                return nil, parseError{tmpl.curline, "unmatched close tag"}
+            }
+            if name != section.name && name != helperName(section.name) {
+                return nil, parseError{tmpl.curline, "interleaved closing tag: " + name}
+            }
+            return elems, nil
         case '>':
             name := strings.TrimSpace(tag[1:])
-            partial, err := tmpl.parsePartial(name)
+            if !standalone {
+                indent = ""
+            }
+            if _, ok := tmpl.opts.Partials.(ContextPartialProvider); ok {
+                elems = append(elems, tmpl.arena.partial(partialElement{name, nil, &tmpl.opts, indent, tagpos}))
+                break
+            }
+            if partial, ok := tmpl.included[tmpl.includedKey(name, indent)]; ok {
+                elems = append(elems, tmpl.arena.partial(partialElement{name, partial, &tmpl.opts, indent, tagpos}))
+                break
+            }
+            if tmpl.depth >= tmpl.opts.MaxDepth {
+                return nil, &LimitError{tmpl.curline, "nesting depth", tmpl.opts.MaxDepth}
+            }
+            tmpl.depth++
+            partial, err := tmpl.parsePartial(name, tagpos.line, indent)
+            tmpl.depth--
             if err != nil {
-                return err
+                return nil, err
             }
-            tmpl.elems = append(tmpl.elems, partial)
-        case '=':
-            if tag[len(tag)-1] != '=' {
-                return parseError{tmpl.curline, "Invalid meta tag"}
+            if partial.nesting > tmpl.nesting {
+                tmpl.nesting = partial.nesting
             }
-            tag = strings.TrimSpace(tag[1 : len(tag)-1])
-            newtags := strings.SplitN(tag, " ", 2)
-            if len(newtags) == 2 {
-                tmpl.otag = newtags[0]
-                tmpl.ctag = newtags[1]
+            elems = append(elems, tmpl.arena.partial(partialElement{name, partial, &tmpl.opts, indent, tagpos}))
+        case '=':
+            err := tmpl.setDelimiters(tag)
+            if err != nil {
+                return nil, err
             }
         case '{':
             //use a raw tag
-            if tag[len(tag)-1] == '}' {
-                tmpl.elems = append(tmpl.elems, &varElement{tag[1 : len(tag)-1], true})
+            if i := strings.Index(tag, tmpl.ctag); i >= 0 {
+                // the closing brace is missing, so the tag ran on into
+                // the next tag with three closing braces
+                return nil, tmpl.malformedRaw(tagpos, tag[:i])
+            }
+            if tag[len(tag)-1] != '}' {
+                return nil, tmpl.malformedRaw(tagpos, tag)
             }
+            elems = append(elems, tmpl.variable(tag[1:len(tag)-1], true, tagpos))
+        case '&':
+            elems = append(elems, tmpl.variable(strings.TrimSpace(tag[1:]), true, tagpos))
         default:
-            tmpl.elems = append(tmpl.elems, &varElement{tag, false})
+            if tag[0] == '@' && tmpl.opts.Assets != nil {
+                text, err := tmpl.asset(strings.TrimSpace(tag[1:]), tagpos)
+                if err != nil {
+                    return nil, err
+                }
+                elems = appendText(elems, tmpl.arena.text(textElement{text, tagpos}))
+                break
+            }
+            if c := unknownSigil(tag); c != 0 && tmpl.opts.RejectUnknownSigils {
+                return nil, parseError{tagpos.line, fmt.Sprintf("unknown sigil %q in tag %s", c, tmpl.otag+tag+tmpl.ctag)}
+            }
+            elems = append(elems, tmpl.variable(tag, false, tagpos))
         }
     }
-
-    return nil
 }
 
 // See if name is a method of the value at some level of indirection.
@@ -343,33 +691,82 @@ func call(v reflect.Value, method reflect.Method) reflect.Value {
 
 // Evaluate interfaces and pointers looking for a value that can look up the name, via a
 // struct field, method, or map key, and return the result of the lookup.
-func lookup(contextChain []interface{}, name string) reflect.Value {
+// The context chain is ordered innermost last, so later contexts take
+// precedence, and the template's defaults come after all of it.
+// Methods taking a single context.Context are passed the one the render
+// was given.
+func (r *renderer) lookup(contextChain []reflect.Value, name string) reflect.Value {
+    v, _ := r.lookupDefault(contextChain, name)
+    return v
+}
+
+// lookupDefault is lookup, also reporting whether the value comes from the
+// template's defaults.
+func (r *renderer) lookupDefault(contextChain []reflect.Value, name string) (reflect.Value, bool) {
+    if b := r.opts.Budget; b != nil {
+        if r.spend(&b.Lookups, 1, b.MaxLookups, "lookups"); r.err != nil {
+            return reflect.Value{}, false
+        }
+    }
+    v := r.find(contextChain, name)
+    if !v.IsValid() && r.defaults.IsValid() {
+        return r.find([]reflect.Value{r.defaults}, name), true
+    }
+    return v, false
+}
+
+// panicked reports a panic recovered while looking name up.
+func (r *renderer) panicked(name string, err interface{}) {
+    if r.opts.NoPanic {
+        if r.err == nil {
+            r.err = &PanicError{name, err, debug.Stack()}
+        }
+        return
+    }
+    if r.opts.Logger == nil {
+        fmt.Printf("Panic while looking up %q: %s\n", name, err)
+        return
+    }
+    r.opts.Logger.Warn("mustache: panic while looking up a name", "name", name, "panic", err)
+}
+
+func (r *renderer) find(contextChain []reflect.Value, name string) reflect.Value {
     // dot notation
     if name != "." && strings.Contains(name, ".") {
         parts := strings.SplitN(name, ".", 2)
-        
-        v := lookup(contextChain, parts[0])
-        return lookup([]interface{}{v}, parts[1])
+
+        v := r.find(contextChain, parts[0])
+        return r.find([]reflect.Value{v}, parts[1])
     }
 
     defer func() {
-        if r := recover(); r != nil {
-            fmt.Printf("Panic while looking up %q: %s\n", name, r)
+        if err := recover(); err != nil {
+            r.panicked(name, err)
         }
     }()
 
 Outer:
-    for _, ctx := range contextChain { //i := len(contextChain) - 1; i >= 0; i-- {
-        v := ctx.(reflect.Value)
+    for i := len(contextChain) - 1; i >= 0; i-- {
+        v := contextChain[i]
+        if r.opts.PreferFields {
+            if ret := r.fieldOrKey(indirect(v), name); ret.IsValid() {
+                return ret
+            }
+        }
         for v.IsValid() {
             typ := v.Type()
-            if n := v.Type().NumMethod(); n > 0 {
-                for i := 0; i < n; i++ {
-                    m := typ.Method(i)
-                    mtyp := m.Type
-                    if m.Name == name && mtyp.NumIn() == 1 {
-                        return v.Method(i).Call(nil)[0]
-                    }
+            if m, ok := typ.MethodByName(name); ok {
+                if m.Type.NumIn() == 1 {
+                    return v.Method(m.Index).Call(nil)[0]
+                }
+                if m.Type.NumIn() == 2 && m.Type.In(1) == contextType {
+                    return v.Method(m.Index).Call([]reflect.Value{reflect.ValueOf(r.context())})[0]
+                }
+            } else if r.opts.PointerMethods && typ.Kind() != reflect.Ptr && typ.Kind() != reflect.Interface {
+                if m, ok := reflect.PtrTo(typ).MethodByName(name); ok && (m.Type.NumIn() == 1 || m.Type.NumIn() == 2 && m.Type.In(1) == contextType) {
+                    // look again from a pointer, whose method set has it
+                    v = addressOf(v)
+                    continue
                 }
             }
             if name == "." {
@@ -381,7 +778,7 @@ Outer:
             case reflect.Interface:
                 v = av.Elem()
             case reflect.Struct:
-                ret := av.FieldByName(name)
+                ret := r.field(av, name)
                 if ret.IsValid() {
                     return ret
                 } else {
@@ -402,11 +799,163 @@ Outer:
     return reflect.Value{}
 }
 
-func isEmpty(v reflect.Value) bool {
-    if !v.IsValid() || v.Interface() == nil {
-        return true
+// helperName returns the first word of a tag name, which names the helper
+// when the tag calls one, as in {{#top5 products}}.
+func helperName(name string) string {
+    if i := strings.IndexByte(name, ' '); i >= 0 {
+        return name[:i]
+    }
+    return name
+}
+
+// value looks up the value of a variable or section tag. If the tag's first
+// word names a function, given arguments by the following words or set
+// with SetDefaults, the value is the result of calling it with the values
+// of the arguments; an error calling it is kept in r.err. A function in the
+// data named without arguments is a value like any other.
+func (r *renderer) value(contextChain []reflect.Value, name string, at pos) reflect.Value {
+    hasArgs := len(name) > len(helperName(name))
+    fn, isDefault := r.lookupDefault(contextChain, helperName(name))
+    for fn.Kind() == reflect.Interface && !fn.IsNil() {
+        fn = fn.Elem()
+    }
+    if fn.Kind() != reflect.Func || fn.IsNil() {
+        if hasArgs {
+            return r.lookup(contextChain, name)
+        }
+        return fn
+    }
+    if !hasArgs && !isDefault {
+        return fn
+    }
+    val, err := r.call(fn, contextChain, strings.Fields(name)[1:])
+    if err != nil {
+        r.err = fmt.Errorf("line %d: calling %s: %v", at.line, helperName(name), err)
+    }
+    return val
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// call calls a helper function with the values of the named arguments. A
+// helper returns a value and optionally an error.
+func (r *renderer) call(fn reflect.Value, contextChain []reflect.Value, args []string) (reflect.Value, error) {
+    typ := fn.Type()
+    var in []reflect.Value
+    if typ.NumIn() > 0 && typ.In(0) == contextType {
+        // a helper taking a context first gets the render's
+        in = append(in, reflect.ValueOf(r.context()))
+    }
+    if n := typ.NumIn() - len(in); len(args) != n && !(typ.IsVariadic() && len(args) >= n-1) {
+        return reflect.Value{}, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+    }
+    for _, arg := range args {
+        i := len(in)
+        var want reflect.Type
+        if typ.IsVariadic() && i >= typ.NumIn()-1 {
+            want = typ.In(typ.NumIn() - 1).Elem()
+        } else {
+            want = typ.In(i)
+        }
+        v := r.lookup(contextChain, arg)
+        for v.Kind() == reflect.Interface && !v.IsNil() {
+            v = v.Elem()
+        }
+        switch {
+        case !v.IsValid() || v.Kind() == reflect.Interface:
+            v = reflect.Zero(want)
+        case !v.Type().AssignableTo(want):
+            return reflect.Value{}, fmt.Errorf("cannot use %s (%s) as %s", arg, v.Type(), want)
+        }
+        in = append(in, v)
+    }
+
+    out := fn.Call(in)
+    if n := len(out); n > 0 && typ.Out(n-1) == errorType {
+        if err, _ := out[n-1].Interface().(error); err != nil {
+            return reflect.Value{}, err
+        }
+        out = out[:n-1]
+    }
+    if len(out) == 0 {
+        return reflect.Value{}, nil
+    }
+    return out[0], nil
+}
+
+// addressOf returns a pointer to v, or to a copy of v if it is not
+// addressable.
+func addressOf(v reflect.Value) reflect.Value {
+    if v.CanAddr() {
+        return v.Addr()
     }
+    p := reflect.New(v.Type())
+    p.Elem().Set(v)
+    return p
+}
+
+// fieldOrKey looks name up as a field of a struct or a key of a map.
+func (r *renderer) fieldOrKey(v reflect.Value, name string) reflect.Value {
+    switch v.Kind() {
+    case reflect.Struct:
+        return r.field(v, name)
+    case reflect.Map:
+        key := reflect.ValueOf(name)
+        if key.Type().AssignableTo(v.Type().Key()) {
+            return v.MapIndex(key)
+        }
+    }
+    return reflect.Value{}
+}
+
+// field looks name up as a field of the struct v. Unexported fields, whose
+// values cannot be rendered, are skipped, and so are fields promoted from a
+// nil embedded pointer. Under SpecStrict, an unexported field is an error.
+func (r *renderer) field(v reflect.Value, name string) reflect.Value {
+    sf, ok := v.Type().FieldByName(name)
+    if !ok {
+        return reflect.Value{}
+    }
+    if sf.PkgPath != "" {
+        if r.strict && r.err == nil {
+            r.err = fmt.Errorf("field %s of %s is unexported", name, v.Type())
+        }
+        return reflect.Value{}
+    }
+    f, err := v.FieldByIndexErr(sf.Index)
+    if err != nil {
+        return reflect.Value{}
+    }
+    return f
+}
 
+// SectionIterable is implemented by values that choose the items a section
+// over them iterates, such as a page of results or a custom collection. A
+// section over a value returning no items renders as a section over an
+// empty list does.
+type SectionIterable interface {
+    MustacheItems() []interface{}
+}
+
+// sectionItems returns the items of v if it is a SectionIterable, possibly
+// through pointers and interfaces.
+func sectionItems(v reflect.Value) ([]interface{}, bool) {
+    for v.IsValid() && v.CanInterface() {
+        if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+            break
+        }
+        if it, ok := v.Interface().(SectionIterable); ok {
+            return it.MustacheItems(), true
+        }
+        if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+            break
+        }
+        v = v.Elem()
+    }
+    return nil, false
+}
+
+func isEmpty(v reflect.Value) bool {
     valueInd := indirect(v)
     if !valueInd.IsValid() {
         return true
@@ -414,7 +963,7 @@ func isEmpty(v reflect.Value) bool {
     switch val := valueInd; val.Kind() {
     case reflect.Bool:
         return !val.Bool()
-    case reflect.Slice:
+    case reflect.Slice, reflect.Array:
         return val.Len() == 0
     }
 
@@ -436,87 +985,1072 @@ loop:
     return v
 }
 
-func renderSection(section *sectionElement, contextChain []interface{}, buf io.Writer) {
-    value := lookup(contextChain, section.name)
-    var context = contextChain[len(contextChain)-1].(reflect.Value)
-    var contexts = []interface{}{}
-    // if the value is nil, check if it's an inverted section
-    isEmpty := isEmpty(value)
-    if isEmpty && !section.inverted || !isEmpty && section.inverted {
-        return
-    } else if !section.inverted {
-        valueInd := indirect(value)
-        switch val := valueInd; val.Kind() {
-        case reflect.Slice:
-            for i := 0; i < val.Len(); i++ {
-                contexts = append(contexts, val.Index(i))
+// RenderOptions controls a single render.
+type RenderOptions struct {
+    // FlushText flushes the output after each run of literal text, if the
+    // output has a Flush method, as http.ResponseWriter usually does.
+    FlushText bool
+    // FlushThreshold, if positive, flushes such an output whenever at
+    // least this many bytes have been written since the last flush.
+    FlushThreshold int
+    // Context is passed to a ContextPartialProvider and to methods of the
+    // data that take a context.Context. It defaults to
+    // context.Background().
+    Context context.Context
+    // FormatFloat, if not nil, formats interpolated floating-point numbers,
+    // for example to round them to a fixed precision. By default they are
+    // formatted with as few digits as represent them exactly and never with
+    // an exponent, so float64(3) renders as 3, like int(3).
+    FormatFloat func(f float64) string
+    // Coverage, if not nil, records the tags the render exercises.
+    Coverage *Coverage
+    // MaxContextDepth is the most contexts sections may stack up, which
+    // bounds renders of data that refers back to itself, such as a method
+    // returning its receiver, through recursive partials. A render going
+    // deeper fails with a *LimitError. Zero selects
+    // DefaultMaxContextDepth.
+    MaxContextDepth int
+    // PreferFields looks names up as struct fields and map keys before
+    // methods, so that a key such as "Error" in a map type with an Error
+    // method can be reached. By default methods win.
+    PreferFields bool
+    // PointerMethods lets names reach methods with pointer receivers on
+    // values that are not pointers, as text/template does. The method is
+    // called on the value itself if it is addressable, such as an element
+    // of a slice, and otherwise on a copy.
+    PointerMethods bool
+    // Partials, if not nil, supplies the partials of this render in place
+    // of those resolved when the template was parsed, so that a template
+    // can be compiled once and rendered with, say, each tenant's partials.
+    // Partials are then fetched and parsed as they are rendered. The
+    // template must still parse, so parse it with a provider that knows
+    // every partial, such as a default tenant's, or with a
+    // ContextPartialProvider, whose partials are not fetched when parsing.
+    Partials PartialProvider
+    // PartialOverrides maps the names of partials to sources that replace
+    // them for the render, taking precedence over Partials and over the
+    // template's provider, so that a preview can swap in, say, a new
+    // header. Keys are names as asked of providers, after
+    // ParseOptions.RewritePartialName.
+    PartialOverrides map[string]string
+    // Budget, if not nil, counts the work of the render, and may limit it.
+    Budget *Budget
+    // Placeholder, if not nil, renders a variable tag that finds no value
+    // as the text it returns for the tag's name, written unescaped, rather
+    // than as nothing, so that blanks stand out in design-review renders.
+    Placeholder func(name string) string
+    // MaxValueLength, if positive, is the most characters an interpolated
+    // value may render as. A longer value is cut short and ends with an
+    // ellipsis, counted in the limit. Values are cut before they are
+    // escaped, so escaping can make the output longer.
+    MaxValueLength int
+    // Logger, if not nil, is told of the panics recovered while looking
+    // names up, which are otherwise printed to standard output. A
+    // *slog.Logger will do.
+    Logger Logger
+    // NoPanic guarantees that the render does not panic, whatever the
+    // template and the shape of the data: a panic, in this package or in
+    // a method, helper or writer called by the render, stops the render
+    // with a *PanicError. A panic while looking a name up is then an error
+    // too, rather than logged and rendered as nothing. The guarantee is for
+    // servers where a panic would take down work other than the render's.
+    NoPanic bool
+    // Debug appends an HTML comment describing the render to the output,
+    // so that a page can be traced back to what made it, as in
+    // <!-- mustache {"template":"page.mustache","hash":"…",
+    // "partials":["header"],"duration":"1.2ms"} -->. It gives the base name
+    // of the template's file, if it was parsed from one, its Hash, the
+    // partials it rendered and the time the render took. It is meant to be
+    // turned on by environment, say in staging.
+    Debug bool
+    // Comments renders comment tags as HTML comments, so that the output
+    // of a development build can be traced back to the templates. Any "--"
+    // in a comment is written as "- -", which HTML comments cannot hold.
+    Comments bool
+
+    // By default, when several contexts are passed to a render, a name
+    // resolves in the first context holding it.
+
+    // LastContextWins resolves names in the last context holding them
+    // instead, so that later contexts override earlier ones.
+    LastContextWins bool
+    // MergeContexts merges the contexts that are maps with string keys
+    // into one, as MergeData does, in place of the one of them that takes
+    // precedence. Unlike a plain lookup, which stops at the first map
+    // holding {"user": ...}, this lets user.name come from one context
+    // and user.email from another.
+    MergeContexts bool
+
+    // Yield, if not nil, is called when the render reaches a marker, a
+    // comment tag starting with @ such as {{!@head}}, with the marker's
+    // name. The output so far is flushed first, if out has a Flush method,
+    // so that an HTTP handler can send the head of a page while the data
+    // of later sections, which Yield may wait for, is still loading. An
+    // error from Yield stops the render. Minify keeps markers.
+    Yield func(marker string) error
+
+    // BufferSize is the size of the buffer that collects the output, so
+    // that the many small pieces of a render reach out in few writes. Zero
+    // selects DefaultBufferSize, and a negative size writes each piece as
+    // it is rendered. Outputs that are buffers already, such as a
+    // *bytes.Buffer, are never buffered again. The buffer is flushed at
+    // the end of the render and before flushing out.
+    BufferSize int
+
+    // Redact, if not nil, is given the path and the text of each value
+    // interpolated, and returns the text to render in its place, so that
+    // secrets can be masked whatever the template refers to. The path is
+    // the tag's name prefixed with the names of the sections it is in, as
+    // in user.password for {{password}} in {{#user}}. RedactMatching makes
+    // a simple Redact.
+    Redact func(path, value string) string
+
+    // Now, if not nil, is the time source of the render, returned by the
+    // Now function to the methods and helpers taking a context.Context.
+    Now func() time.Time
+    // Reproducible makes the output depend only on the template and the
+    // data, for golden tests and content-addressed caches: Now defaults to
+    // ReproducibleTime, and interpolating a value that renders as a memory
+    // address, such as a channel, is an error. Maps have no order to fix,
+    // since sections never iterate them, and numbers are always formatted
+    // the same way.
+    Reproducible bool
+}
+
+// ReproducibleTime is the time of a Reproducible render that sets no Now.
+var ReproducibleTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// RedactMatching returns a function for RenderOptions.Redact that renders
+// the values whose path re matches as [REDACTED].
+func RedactMatching(re *regexp.Regexp) func(path, value string) string {
+    return func(path, value string) string {
+        if re.MatchString(path) {
+            return "[REDACTED]"
+        }
+        return value
+    }
+}
+
+const DefaultBufferSize = 4096
+
+// contexts returns the contexts passed to a render ordered as the options
+// require, the one taking precedence first.
+func (opts *RenderOptions) contexts(context []interface{}) []interface{} {
+    if !opts.LastContextWins && !opts.MergeContexts {
+        return context
+    }
+    ordered := make([]interface{}, 0, len(context))
+    for i := range context {
+        if opts.LastContextWins {
+            ordered = append(ordered, context[len(context)-1-i])
+        } else {
+            ordered = append(ordered, context[i])
+        }
+    }
+    if !opts.MergeContexts {
+        return ordered
+    }
+    merged := map[string]interface{}{}
+    first := -1
+    for i := len(ordered) - 1; i >= 0; i-- {
+        if m, ok := stringMap(ordered[i]); ok {
+            MergeData(merged, m)
+            first = i
+        }
+    }
+    if first < 0 {
+        return ordered
+    }
+    var result []interface{}
+    for i, c := range ordered {
+        if i == first {
+            result = append(result, merged)
+        } else if _, ok := stringMap(c); !ok {
+            result = append(result, c)
+        }
+    }
+    return result
+}
+
+// PanicError is returned by a render with RenderOptions.NoPanic when it
+// panics.
+type PanicError struct {
+    // Name is the name being looked up when the panic happened, if any.
+    Name string
+    // Value is the value the render panicked with.
+    Value interface{}
+    // Stack is the stack of the goroutine when it panicked.
+    Stack []byte
+}
+
+func (e *PanicError) Error() string {
+    if e.Name != "" {
+        return fmt.Sprintf("panic while looking up %q: %v", e.Name, e.Value)
+    }
+    return fmt.Sprintf("panic while rendering: %v", e.Value)
+}
+
+// Logger receives the messages of a render. It is satisfied by *slog.Logger.
+type Logger interface {
+    Warn(msg string, args ...interface{})
+}
+
+const DefaultMaxContextDepth = 1000
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// flusher is implemented by outputs that buffer, such as the
+// http.ResponseWriter of most servers.
+type flusher interface {
+    Flush()
+}
+
+// renderer holds the state of a single render. Rendering stops at the first
+// error, which is kept in err.
+type renderer struct {
+    out       io.Writer
+    buf       *bufio.Writer // buffers out, if not nil
+    opts      *RenderOptions
+    flusher   flusher
+    unflushed int
+    depth     int
+    defaults  reflect.Value
+    escape    func(string) string
+    strict    bool
+    report    *RenderReport
+    ctx       context.Context  // see context
+    sections  []string         // the names of the enclosing sections, for Redact
+    line      int              // the line of the element rendered, with a Budget
+    url       *strings.Builder // the URL a URLSection is building, if any
+    partials  []string         // the partials rendered, with RenderOptions.Debug
+    err       error
+}
+
+func (r *renderer) context() context.Context {
+    if r.ctx != nil {
+        return r.ctx
+    }
+    r.ctx = r.opts.Context
+    if r.ctx == nil {
+        r.ctx = context.Background()
+    }
+    now := r.opts.Now
+    if now == nil && r.opts.Reproducible {
+        now = func() time.Time { return ReproducibleTime }
+    }
+    if now != nil {
+        r.ctx = context.WithValue(r.ctx, nowKey{}, now)
+    }
+    return r.ctx
+}
+
+type nowKey struct{}
+
+// Now returns the current time as seen by the render ctx was passed to by a
+// method or helper, which is the time RenderOptions.Now returns, if set, so
+// that helpers formatting times can be tested. Otherwise it is time.Now().
+func Now(ctx context.Context) time.Time {
+    if now, ok := ctx.Value(nowKey{}).(func() time.Time); ok {
+        return now()
+    }
+    return time.Now()
+}
+
+// fetchPartial fetches the source of a partial resolved at render time.
+func (r *renderer) fetchPartial(partials PartialProvider, name string) (string, error) {
+    if cp, ok := partials.(ContextPartialProvider); ok {
+        return cp.GetContext(r.context(), name)
+    }
+    return partials.Get(name)
+}
+
+// memoized reports whether the render may write the memoized output of
+// static templates and sections, rather than walk them.
+func (r *renderer) memoized() bool {
+    return r.opts.Coverage == nil && r.opts.Partials == nil && r.opts.PartialOverrides == nil && r.opts.Budget == nil && !r.opts.Debug && !r.opts.Comments && r.opts.Yield == nil
+}
+
+// usedPartial records that the render included the partial called name,
+// for RenderOptions.Debug.
+func (r *renderer) usedPartial(name string) {
+    for _, used := range r.partials {
+        if used == name {
+            return
+        }
+    }
+    r.partials = append(r.partials, name)
+}
+
+// renderPartial renders a partial, fetching and parsing it first if it is
+// resolved at render time.
+func (r *renderer) renderPartial(partial *partialElement, contextChain []reflect.Value) {
+    if r.depth >= partial.opts.MaxDepth {
+        r.err = &LimitError{partial.line, "nesting depth", partial.opts.MaxDepth}
+        return
+    }
+    if b := r.opts.Budget; b != nil {
+        if r.spend(&b.Partials, 1, b.MaxPartials, "partials"); r.err != nil {
+            return
+        }
+    }
+    tmpl := partial.tmpl
+    name := partial.opts.partialName(partial.name)
+    if r.opts.Debug {
+        r.usedPartial(name)
+    }
+    data, overridden := r.opts.PartialOverrides[name]
+    if tmpl == nil || r.opts.Partials != nil || overridden {
+        opts := *partial.opts
+        if r.opts.Partials != nil {
+            opts.Partials = r.opts.Partials
+        }
+        if !overridden {
+            var err error
+            data, err = r.fetchPartial(opts.Partials, name)
+            if _, ok := err.(*PartialNotFoundError); ok && opts.SpecStrict {
+                data, err = "", nil
             }
-        case reflect.Array:
+            if err != nil {
+                r.err = err
+                return
+            }
+        }
+        tmpl = newTemplate([]byte(indentLines(data, partial.indent)), opts)
+        if err := tmpl.parse(); err != nil {
+            r.err = &PartialError{partial.name, partial.line, err}
+            return
+        }
+    }
+    if r.opts.Coverage != nil {
+        r.opts.Coverage.record(partial, coverRendered)
+    }
+    if tmpl.static != nil && r.memoized() {
+        r.renderStatic(tmpl.static)
+        return
+    }
+    r.depth++
+    r.renderElements(tmpl.elems, contextChain)
+    r.depth--
+    if r.err != nil {
+        r.err = &PartialError{partial.name, partial.line, r.err}
+    }
+}
+
+func newRenderer(out io.Writer, opts *RenderOptions) renderer {
+    r := renderer{out: out, opts: opts}
+    if opts.FlushText || opts.FlushThreshold > 0 || opts.Yield != nil {
+        r.flusher, _ = out.(flusher)
+    }
+    size := opts.BufferSize
+    if size == 0 {
+        size = DefaultBufferSize
+    }
+    switch out.(type) {
+    case *bytes.Buffer, *strings.Builder, *bufio.Writer:
+        // writing to these is cheap already
+    default:
+        if size > 0 {
+            r.buf = bufio.NewWriterSize(out, size)
+            r.out = r.buf
+        }
+    }
+    return r
+}
+
+// writeValue writes an interpolated value, escaping it unless raw is true.
+// In the body of a URLSection, it is escaped for the part of the URL it is
+// in instead, and the URL as a whole is escaped once it is complete.
+func (r *renderer) writeValue(s string, raw bool) {
+    switch {
+    case r.url != nil && !raw:
+        r.writeString(escapeURLPart(r.url.String(), s))
+    case raw:
+        r.writeString(s)
+    case r.escape != nil:
+        r.writeString(r.escape(s))
+    case r.strict:
+        r.writeEscaped(specEscaper, s)
+    default:
+        r.writeEscaped(htmlEscaper, s)
+    }
+}
+
+// renderURL renders the body of a URLSection.
+func (r *renderer) renderURL(section *sectionElement, contextChain []reflect.Value) {
+    var url strings.Builder
+    out, outer, flusher := r.out, r.url, r.flusher
+    r.out, r.url, r.flusher = &url, &url, nil
+    r.renderBody(section, contextChain)
+    r.out, r.url, r.flusher = out, outer, flusher
+    r.writeValue(url.String(), false)
+}
+
+// renderClasses renders the body of a ClassSection.
+func (r *renderer) renderClasses(section *sectionElement, contextChain []reflect.Value) {
+    var body strings.Builder
+    out, flusher := r.out, r.flusher
+    r.out, r.flusher = &body, nil
+    r.renderBody(section, contextChain)
+    r.out, r.flusher = out, flusher
+    var classes []string
+    for _, item := range strings.Split(body.String(), ",") {
+        class, flag, conditional := strings.Cut(item, ":")
+        class = strings.TrimSpace(class)
+        if class == "" || conditional && isEmpty(r.lookup(contextChain, strings.TrimSpace(flag))) {
+            continue
+        }
+        classes = append(classes, class)
+    }
+    r.writeString(strings.Join(classes, " "))
+}
+
+// The write methods write to the output unless the render already failed.
+// A failed or short write fails the render.
+
+func (r *renderer) write(p []byte) {
+    if r.err == nil {
+        n, err := r.out.Write(p)
+        r.wrote(n, len(p), err)
+    }
+}
+
+func (r *renderer) writeString(s string) {
+    if r.err == nil {
+        n, err := io.WriteString(r.out, s)
+        r.wrote(n, len(s), err)
+    }
+}
+
+func (r *renderer) writeEscaped(escaper *strings.Replacer, s string) {
+    if r.err == nil {
+        // the escaped length is unknown, so only errors are caught
+        n, err := escaper.WriteString(r.out, s)
+        r.wrote(n, n, err)
+    }
+}
+
+// wrote accounts for n bytes written out of the want bytes given.
+func (r *renderer) wrote(n, want int, err error) {
+    if err == nil && n < want {
+        err = io.ErrShortWrite
+    }
+    if err != nil {
+        r.err = err
+        return
+    }
+    if b := r.opts.Budget; b != nil {
+        r.spend(&b.Bytes, n, b.MaxBytes, "bytes")
+    }
+    if r.flusher == nil {
+        return
+    }
+    r.unflushed += n
+    if r.opts.FlushThreshold > 0 && r.unflushed >= r.opts.FlushThreshold {
+        r.flush()
+    }
+}
+
+func (r *renderer) flush() {
+    if r.flusher != nil && r.unflushed > 0 {
+        r.flushBuffer()
+        r.flusher.Flush()
+        r.unflushed = 0
+    }
+}
+
+// flushBuffer writes out what the render's buffer holds, if it has one.
+func (r *renderer) flushBuffer() {
+    if r.buf == nil {
+        return
+    }
+    if err := r.buf.Flush(); err != nil && r.err == nil {
+        r.err = err
+    }
+}
+
+// renderSection renders a section. Its value is looked at through any
+// pointers and interfaces: a slice or array, however reached, is iterated,
+// and is false when empty, and a nil pointer is false.
+func (r *renderer) renderSection(section *sectionElement, contextChain []reflect.Value) {
+    max := r.opts.MaxContextDepth
+    if max == 0 {
+        max = DefaultMaxContextDepth
+    }
+    if len(contextChain) >= max {
+        r.err = &LimitError{section.line, "context depth", max}
+        return
+    }
+    value := r.value(contextChain, section.name, section.pos)
+    if r.err != nil {
+        return
+    }
+    if items, ok := sectionItems(value); ok {
+        value = reflect.ValueOf(items)
+    }
+    var context reflect.Value
+    if len(contextChain) > 0 {
+        context = contextChain[0]
+    }
+    // if the value is nil, check if it's an inverted section
+    isEmpty := isEmpty(value)
+    if isEmpty && !section.inverted || !isEmpty && section.inverted {
+        if r.opts.Coverage != nil {
+            r.opts.Coverage.record(section, coverSkipped)
+        }
+        return
+    }
+    if r.opts.Coverage != nil {
+        r.opts.Coverage.record(section, coverRendered)
+    }
+    if r.strict {
+        context = value
+    }
+    if !section.inverted {
+        if r.opts.Redact != nil {
+            r.sections = append(r.sections, section.name)
+            defer func() { r.sections = r.sections[:len(r.sections)-1] }()
+        }
+        valueInd := indirect(value)
+        if valueInd.IsValid() {
+            switch valueInd.Type() {
+            case urlSectionType:
+                r.renderURL(section, append(contextChain, context))
+                return
+            case classSectionType:
+                r.renderClasses(section, append(contextChain, context))
+                return
+            }
+        }
+        switch val := valueInd; val.Kind() {
+        case reflect.Slice, reflect.Array:
             for i := 0; i < val.Len(); i++ {
-                contexts = append(contexts, val.Index(i))
+                r.renderBody(section, append(contextChain, val.Index(i)))
             }
+            return
         case reflect.Map, reflect.Struct:
-            contexts = append(contexts, value)
-        default:
-            contexts = append(contexts, context)
+            context = value
         }
-    } else if section.inverted {
-        contexts = append(contexts, context)
     }
 
-    chain2 := make([]interface{}, len(contextChain)+1)
-    copy(chain2[1:], contextChain)
     //by default we execute the section
-    for _, ctx := range contexts {
-        chain2[0] = ctx
-        for _, elem := range section.elems {
-            renderElement(elem, chain2, buf)
+    r.renderBody(section, append(contextChain, context))
+}
+
+func (r *renderer) renderBody(section *sectionElement, contextChain []reflect.Value) {
+    if section.static != nil && r.memoized() {
+        r.renderStatic(section.static)
+        return
+    }
+    r.renderElements(section.elems, contextChain)
+}
+
+// renderStatic writes the memoized output of a section body or partial.
+func (r *renderer) renderStatic(static []byte) {
+    r.write(static)
+    if r.opts.FlushText {
+        r.flush()
+    }
+}
+
+// truncate shortens s to at most n characters, the last of them an
+// ellipsis, if it is longer.
+func truncate(s string, n int) string {
+    if utf8.RuneCountInString(s) <= n {
+        return s
+    }
+    i := 0
+    for j := range s {
+        if i == n-1 {
+            return s[:j] + "…"
+        }
+        i++
+    }
+    return s
+}
+
+var (
+    // htmlEscaper escapes text as html/template's HTMLEscapeString does,
+    // without pulling that package into small builds such as TinyGo's.
+    htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;", "\x00", "\uFFFD")
+    // specEscaper escapes the characters the mustache spec requires, in
+    // the way its tests expect.
+    specEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+var (
+    stringType    = reflect.TypeOf("")
+    stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+    formatterType = reflect.TypeOf((*fmt.Formatter)(nil)).Elem()
+)
+
+// formatValue renders val with the given fmt verb, such as %06.2f, or as
+// stringValue does if there is none. Pointers are formatted as what they
+// point to, unless they format themselves.
+func (r *renderer) formatValue(val reflect.Value, format string) string {
+    if format == "" {
+        return r.stringValue(val)
+    }
+    for (val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr && !val.Type().Implements(formatterType) &&
+        !val.Type().Implements(stringerType) && !val.Type().Implements(errorType)) && !val.IsNil() {
+        val = val.Elem()
+    }
+    if !val.CanInterface() {
+        return r.stringValue(val)
+    }
+    return fmt.Sprintf(format, val.Interface())
+}
+
+// stringValue formats an interpolated value, without allocating for plain
+// strings.
+func (r *renderer) stringValue(val reflect.Value) string {
+    // render what pointers point to, unless they format themselves
+    for (val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr &&
+        !val.Type().Implements(stringerType) && !val.Type().Implements(errorType)) && !val.IsNil() {
+        val = val.Elem()
+    }
+    switch val.Kind() {
+    case reflect.String:
+        if val.Type() == stringType {
+            return val.String()
         }
+    case reflect.Float32, reflect.Float64:
+        if val.Type().Implements(stringerType) {
+            break
+        }
+        if r.opts.FormatFloat != nil {
+            return r.opts.FormatFloat(val.Float())
+        }
+        return strconv.FormatFloat(val.Float(), 'f', -1, val.Type().Bits())
     }
+    return fmt.Sprint(val.Interface())
 }
 
-func renderElement(element interface{}, contextChain []interface{}, buf io.Writer) {
+func (r *renderer) renderElement(element interface{}, contextChain []reflect.Value) {
+    if b := r.opts.Budget; b != nil {
+        r.line = element.(interface{ position() pos }).position().line
+        if r.spend(&b.Elements, 1, b.MaxElements, "elements"); r.err != nil {
+            return
+        }
+    }
     switch elem := element.(type) {
     case *textElement:
-        buf.Write(elem.text)
+        r.write(elem.text)
+        if r.opts.FlushText {
+            r.flush()
+        }
     case *varElement:
         defer func() {
-            if r := recover(); r != nil {
-                fmt.Printf("Panic while looking up %q: %s\n", elem.name, r)
+            if err := recover(); err != nil {
+                r.panicked(elem.name, err)
             }
         }()
-        val := lookup(contextChain, elem.name)
+        val := r.value(contextChain, elem.name, elem.pos)
+        if r.opts.Coverage != nil {
+            r.opts.Coverage.record(elem, coverRendered)
+        }
 
-        if val.IsValid() {
-            if elem.raw {
-                fmt.Fprint(buf, val.Interface())
-            } else {
-                s := fmt.Sprint(val.Interface())
-                template.HTMLEscape(buf, []byte(s))
+        if !val.IsValid() && r.report != nil {
+            r.report.missing(elem.name)
+        }
+        if !val.IsValid() && r.opts.Placeholder != nil {
+            r.writeString(r.opts.Placeholder(elem.name))
+        }
+        if val.IsValid() && !(r.strict && !indirect(val).IsValid()) {
+            if r.opts.Reproducible {
+                switch indirect(val).Kind() {
+                case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+                    r.err = fmt.Errorf("line %d: %s renders as a memory address", elem.line, elem.name)
+                    return
+                }
+            }
+            s := r.formatValue(val, elem.format)
+            if r.opts.Redact != nil {
+                s = r.opts.Redact(strings.Join(append(r.sections, elem.name), "."), s)
             }
+            if r.opts.MaxValueLength > 0 {
+                s = truncate(s, r.opts.MaxValueLength)
+            }
+            r.writeValue(s, elem.raw)
         }
     case *sectionElement:
-        renderSection(elem, contextChain, buf)
-    case *Template:
-        elem.renderTemplate(contextChain, buf)
+        r.renderSection(elem, contextChain)
+    case *partialElement:
+        r.renderPartial(elem, contextChain)
+    case *commentElement:
+        if r.opts.Comments {
+            r.writeString("<!-- " + commentText(elem.text) + " -->")
+        }
+        if marker, ok := elem.marker(); ok && r.opts.Yield != nil {
+            r.flush()
+            r.flushBuffer()
+            r.err = r.opts.Yield(marker)
+        }
     }
 }
 
-func (tmpl *Template) renderTemplate(contextChain []interface{}, buf io.Writer) {
-    for _, elem := range tmpl.elems {
-        renderElement(elem, contextChain, buf)
+func (r *renderer) renderElements(elems []interface{}, contextChain []reflect.Value) {
+    for _, elem := range elems {
+        if r.err != nil {
+            return
+        }
+        r.renderElement(elem, contextChain)
     }
 }
 
+var defaultRenderOptions RenderOptions
+
+func (tmpl *Template) renderTemplate(contextChain []reflect.Value, buf io.Writer) error {
+    return tmpl.renderTemplateOptions(contextChain, buf, &defaultRenderOptions)
+}
+
+func (tmpl *Template) renderTemplateOptions(contextChain []reflect.Value, buf io.Writer, opts *RenderOptions) error {
+    r := newRenderer(buf, opts)
+    return tmpl.renderWith(&r, contextChain)
+}
+
+func (tmpl *Template) renderWith(r *renderer, contextChain []reflect.Value) (err error) {
+    if tmpl.metrics != nil {
+        start := time.Now()
+        defer func() { tmpl.metrics.Rendered(time.Since(start), r.err) }()
+    }
+    if r.opts.NoPanic {
+        defer func() {
+            if v := recover(); v != nil {
+                r.err = &PanicError{"", v, debug.Stack()}
+                err = r.err
+            }
+        }()
+    }
+    start := time.Now()
+    r.defaults = tmpl.defaults
+    r.escape = tmpl.escape
+    r.strict = tmpl.opts.SpecStrict
+    if tmpl.static != nil && r.memoized() {
+        r.renderStatic(tmpl.static)
+    } else {
+        r.renderElements(tmpl.elems, contextChain)
+    }
+    if r.opts.Debug && r.err == nil {
+        r.debugFooter(tmpl, time.Since(start))
+    }
+    r.flush()
+    r.flushBuffer()
+    return r.err
+}
+
+// debugFooter writes the comment RenderOptions.Debug appends to the output.
+func (r *renderer) debugFooter(tmpl *Template, d time.Duration) {
+    var name string
+    if tmpl.filename != "" {
+        name = filepath.Base(tmpl.filename)
+    }
+    info := struct {
+        Template string   `json:"template,omitempty"`
+        Hash     string   `json:"hash"`
+        Partials []string `json:"partials,omitempty"`
+        Duration string   `json:"duration"`
+    }{name, tmpl.Hash(), r.partials, d.String()}
+    data, _ := json.Marshal(info)
+    r.writeString("<!-- mustache " + commentText(string(data)) + " -->")
+}
+
+// commentText returns text with each "--", which HTML comments cannot
+// hold, written as "- -".
+func commentText(text string) string {
+    for strings.Contains(text, "--") {
+        text = strings.Replace(text, "--", "- -", -1)
+    }
+    return text
+}
+
+// IsStatic reports whether the template's output cannot depend on the
+// data: it has no variables or sections, and any partials it includes are
+// static too. Rendering a static template writes its text in one go.
+func (tmpl *Template) IsStatic() bool {
+    return tmpl.static != nil
+}
+
+// Delims returns the delimiters in effect at the end of the template. They
+// are {{ and }} unless the template changes them with a set delimiter tag.
+func (tmpl *Template) Delims() (string, string) {
+    return tmpl.otag, tmpl.ctag
+}
+
+// Options returns the options the template was parsed with, with the
+// defaults filled in for the provider and limits left unset.
+func (tmpl *Template) Options() ParseOptions {
+    return tmpl.opts
+}
+
+// Escape returns the function that escapes the template's interpolated
+// values: the one set with SetEscape if any, or else EscapeHTML, or under
+// SpecStrict a function that escapes double quotes as &quot;.
+func (tmpl *Template) Escape() func(s string) string {
+    switch {
+    case tmpl.escape != nil:
+        return tmpl.escape
+    case tmpl.opts.SpecStrict:
+        return specEscaper.Replace
+    }
+    return EscapeHTML
+}
+
+// Check fetches and parses every partial the template includes, directly or
+// not, without rendering anything, so that a broken or missing partial can
+// be reported at startup rather than by the first render needing it. It
+// matters for partials resolved at render time, as those of a
+// ContextPartialProvider are: partials are looked up with the given
+// provider, or if it is nil with the one the template was parsed with,
+// passing it context.Background().
+func (tmpl *Template) Check(partials PartialProvider) error {
+    return checkPartials(tmpl.elems, partials, map[string]bool{})
+}
+
+func checkPartials(elems []interface{}, partials PartialProvider, seen map[string]bool) error {
+    for _, elem := range elems {
+        switch elem := elem.(type) {
+        case *sectionElement:
+            if err := checkPartials(elem.elems, partials, seen); err != nil {
+                return err
+            }
+        case *partialElement:
+            opts := *elem.opts
+            if partials != nil {
+                opts.Partials = partials
+            }
+            name := opts.partialName(elem.name)
+            if seen[name] {
+                break
+            }
+            seen[name] = true
+            var data string
+            var err error
+            if cp, ok := opts.Partials.(ContextPartialProvider); ok {
+                data, err = cp.GetContext(context.Background(), name)
+            } else {
+                data, opts, err = opts.readPartial(name)
+            }
+            if _, ok := err.(*PartialNotFoundError); ok && opts.SpecStrict {
+                data, err = "", nil
+            }
+            if err != nil {
+                return err
+            }
+            partial := newTemplate([]byte(data), opts)
+            if opts.SpecStrict {
+                partial.included = make(map[string]*Template)
+            }
+            if err = partial.parse(); err != nil {
+                return &PartialError{elem.name, elem.line, err}
+            }
+            next := partials
+            if next != nil {
+                next = opts.Partials
+            }
+            if err = checkPartials(partial.elems, next, seen); err != nil {
+                return &PartialError{elem.name, elem.line, err}
+            }
+        }
+    }
+    return nil
+}
+
+// appendContexts appends the contexts passed to a render call to a context
+// chain, so that the first one takes precedence.
+func appendContexts(contextChain []reflect.Value, context []interface{}) []reflect.Value {
+    for i := len(context) - 1; i >= 0; i-- {
+        contextChain = append(contextChain, reflect.ValueOf(context[i]))
+    }
+    return contextChain
+}
+
+// Render renders the template. If rendering fails, the result is a
+// description of the error. When several contexts are given, each name is
+// looked up in them in turn, so the first context holding a name wins;
+// RenderOptions can change this.
 func (tmpl *Template) Render(context ...interface{}) string {
+    if tmpl.static != nil && tmpl.metrics == nil {
+        return string(tmpl.static)
+    }
+    var sb strings.Builder
+    if err := tmpl.RenderTo(&sb, context...); err != nil {
+        return err.Error()
+    }
+    return sb.String()
+}
+
+// RenderTo renders the template, appending the output to sb, which spares
+// the copy made by Render for callers accumulating output in a builder. If
+// rendering fails, sb may have received part of the output.
+func (tmpl *Template) RenderTo(sb *strings.Builder, context ...interface{}) error {
+    if tmpl.static != nil && tmpl.metrics == nil {
+        sb.Write(tmpl.static)
+        return nil
+    }
+    return tmpl.renderTemplate(appendContexts(nil, context), sb)
+}
+
+// RenderReport is the result of Template.RenderWithReport.
+type RenderReport struct {
+    // Output is the rendered text.
+    Output string
+    // Missing lists the names of the variable tags that found no value,
+    // each once, in the order they were rendered.
+    Missing []string
+}
+
+func (report *RenderReport) missing(name string) {
+    for _, missing := range report.Missing {
+        if missing == name {
+            return
+        }
+    }
+    report.Missing = append(report.Missing, name)
+}
+
+// RenderWithReport renders the template as Render does, and reports the
+// variables that found no value and so rendered as nothing, for tools
+// previewing templates with incomplete data. Missing sections are not
+// reported, since a section is commonly left out of the data on purpose.
+func (tmpl *Template) RenderWithReport(context ...interface{}) (RenderReport, error) {
+    var buf bytes.Buffer
+    var report RenderReport
+    r := newRenderer(&buf, &defaultRenderOptions)
+    r.report = &report
+    err := tmpl.renderWith(&r, appendContexts(nil, context))
+    report.Output = buf.String()
+    return report, err
+}
+
+// FRender renders the template to out. If rendering fails, out may have
+// received part of the output.
+func (tmpl *Template) FRender(out io.Writer, context ...interface{}) error {
+    return tmpl.renderTemplate(appendContexts(nil, context), out)
+}
+
+// FRenderOptions renders the template to out with the given options.
+func (tmpl *Template) FRenderOptions(out io.Writer, opts RenderOptions, context ...interface{}) error {
+    return tmpl.renderTemplateOptions(appendContexts(nil, opts.contexts(context)), out, &opts)
+}
+
+// FallbackError is returned by RenderWithFallback when the primary template
+// failed and the fallback was rendered in its place.
+type FallbackError struct {
+    Err error // the error of the primary template
+}
+
+func (e *FallbackError) Error() string {
+    return "rendered the fallback template: " + e.Err.Error()
+}
+
+func (e *FallbackError) Unwrap() error {
+    return e.Err
+}
+
+// RenderWithFallback renders primary to out or, if it fails, fallback, such
+// as a static error page, so that a broken template never leaves a reader
+// with half a page. The output of primary is buffered, and written only
+// once it has rendered in full. If fallback was rendered, the error is a
+// *FallbackError holding primary's error, to be logged; if fallback fails
+// too, its error is returned, and out may have received part of its output.
+func RenderWithFallback(out io.Writer, primary, fallback *Template, context ...interface{}) error {
+    var buf bytes.Buffer
+    err := primary.FRender(&buf, context...)
+    if err == nil {
+        _, err = buf.WriteTo(out)
+        return err
+    }
+    if ferr := fallback.FRender(out, context...); ferr != nil {
+        return ferr
+    }
+    return &FallbackError{err}
+}
+
+// Context is a prepared chain of contexts to render templates with, for
+// rendering several times with the same data without preparing it again.
+// Its methods may not be called while a render with it is in progress.
+type Context struct {
+    chain []reflect.Value
+}
+
+// NewContext returns a Context holding the given values, in the order
+// Render takes them: the first one holding a name wins.
+func NewContext(values ...interface{}) *Context {
+    return &Context{appendContexts(nil, values)}
+}
+
+// Push adds value to the context, taking precedence over the values already
+// in it, as a section does.
+func (c *Context) Push(value interface{}) {
+    c.chain = append(c.chain, reflect.ValueOf(value))
+}
+
+// Pop removes the value taking precedence, which is the one pushed last.
+// It does nothing if the context is empty.
+func (c *Context) Pop() {
+    if len(c.chain) > 0 {
+        c.chain[len(c.chain)-1] = reflect.Value{}
+        c.chain = c.chain[:len(c.chain)-1]
+    }
+}
+
+// Len returns the number of values in the context.
+func (c *Context) Len() int {
+    return len(c.chain)
+}
+
+// FRenderContext renders the template to out with the given options and
+// a prepared context. Options ordering several contexts, such as
+// MergeContexts, do not apply. Several renders may use the same Context at
+// once.
+func (tmpl *Template) FRenderContext(out io.Writer, opts RenderOptions, c *Context) error {
+    // limit the capacity so that sections pushing onto the chain copy it
+    // rather than write into memory other renders may be reading
+    return tmpl.renderTemplateOptions(c.chain[:len(c.chain):len(c.chain)], out, &opts)
+}
+
+// Execute renders the template with data to w. It has the signature of
+// html/template's Template.Execute, so a Template can be used wherever that
+// is expected.
+func (tmpl *Template) Execute(w io.Writer, data interface{}) error {
+    return tmpl.FRender(w, data)
+}
+
+// RenderJSON renders the template with JSON-encoded data as the context.
+// Numbers are rendered as written in the JSON rather than as formatted
+// float64 values, so 3 stays 3 and large integers keep their digits.
+func (tmpl *Template) RenderJSON(jsonData []byte) (string, error) {
+    var context interface{}
+    dec := json.NewDecoder(bytes.NewReader(jsonData))
+    dec.UseNumber()
+    if err := dec.Decode(&context); err != nil {
+        return "", err
+    }
     var buf bytes.Buffer
-    var contextChain []interface{}
-    for _, c := range context {
-        val := reflect.ValueOf(c)
-        contextChain = append(contextChain, val)
+    if err := tmpl.FRender(&buf, context); err != nil {
+        return "", err
     }
-    tmpl.renderTemplate(contextChain, &buf)
-    return buf.String()
+    return buf.String(), nil
+}
+
+// EscapeHTML escapes the characters special in HTML, as interpolation does
+// by default. Double quotes become &#34; and single quotes &#39;.
+func EscapeHTML(s string) string {
+    return htmlEscaper.Replace(s)
+}
+
+// SetEscape replaces the function that escapes interpolated values, for
+// instance with one escaping more characters than EscapeHTML does. Partials
+// are escaped like the template including them.
+func (tmpl *Template) SetEscape(escape func(s string) string) {
+    tmpl.escape = escape
+}
+
+// SetDefaults sets data to look names up in when no context passed to a
+// render has them, such as site-wide values every render needs. Partials
+// see the defaults of the template including them.
+func (tmpl *Template) SetDefaults(data interface{}) {
+    tmpl.defaults = reflect.ValueOf(data)
 }
 
 func (tmpl *Template) RenderInLayout(layout *Template, context ...interface{}) string {
@@ -527,34 +2061,216 @@ func (tmpl *Template) RenderInLayout(layout *Template, context ...interface{}) s
     return layout.Render(allContext...)
 }
 
+// ParseOptions controls how a template is compiled.
+type ParseOptions struct {
+    // Partials resolves the template's partials. If nil, partials are
+    // looked up as files next to the template or in the working directory.
+    Partials PartialProvider
+    // RewritePartialName, if set, maps the name in each partial tag to the
+    // name asked of Partials, for instance to add a suffix or to map names
+    // that changed.
+    RewritePartialName func(name string) string
+    // Minify drops comment tags and collapses each run of whitespace-only
+    // text between tags to a single newline or space.
+    Minify bool
+    // Raw, if not nil, reports whether a variable tag in double braces,
+    // such as {{body_html}}, renders its value unescaped, as if it were in
+    // triple braces. It is given the tag's name, and lets names holding
+    // trusted markup render raw without editing every template using them.
+    Raw func(name string) bool
+    // FormatVerbs lets a variable tag format its value with a verb of the
+    // fmt package, given after a colon, as in {{price:%.2f}} or
+    // {{id:%06d}}, rather than have the data hold preformatted strings.
+    // It is an extension to mustache, off by default, since a name may
+    // contain ":%" otherwise.
+    FormatVerbs bool
+    // Assets, if not nil, makes tags such as {{@icons/cart.svg}} insert
+    // the asset of that name, such as an SVG image or a little CSS, as it
+    // is. Assets are fetched when the template is parsed and are not
+    // templates, unlike partials. Without Assets, such tags are variables.
+    Assets AssetProvider
+    // EscapeAsset, if not nil, transforms each asset before it is inserted,
+    // for instance to escape an asset shown as text with EscapeHTML.
+    EscapeAsset func(name, data string) string
+    // Arena allocates the elements of the template, and of the partials
+    // parsed with it, from chunks owned by the template, rather than one
+    // by one. It makes fewer, larger allocations, which eases the load on
+    // the garbage collector when parsing many small templates, at the cost
+    // of keeping the chunks alive for as long as any element is.
+    Arena bool
+
+    // The limits below bound the work done on adversarial templates; a
+    // template exceeding one fails with a *LimitError. Zero selects the
+    // corresponding default.
+
+    // MaxTagLength is the longest tag, in bytes.
+    MaxTagLength int
+    // MaxDepth is the deepest nesting of sections and partials.
+    MaxDepth int
+    // MaxDelimiterLength is the longest delimiter, in bytes, that a set
+    // delimiter tag may choose.
+    MaxDelimiterLength int
+
+    // RejectUnknownSigils makes a variable tag whose name starts with a
+    // character a name cannot start with, such as {{@name}}, a parse error
+    // rather than a lookup that never succeeds. Lint reports such tags
+    // regardless.
+    RejectUnknownSigils bool
+
+    // SpecStrict follows the mustache spec where this package otherwise
+    // departs from it: a tag other than a variable alone on its line
+    // removes the whole line, a standalone partial is indented to match,
+    // missing partials and nil values render as nothing, partials may
+    // include themselves, and a section for a value that is not a list
+    // pushes the value itself rather than the outermost context. Double
+    // quotes are escaped as &quot; rather than &#34;. Strings,
+    // empty or not, are always truthy, as in the spec.
+    SpecStrict bool
+}
+
+// RawNames returns a function for ParseOptions.Raw that makes the given
+// names render unescaped.
+func RawNames(names ...string) func(name string) bool {
+    set := make(map[string]bool, len(names))
+    for _, name := range names {
+        set[name] = true
+    }
+    return func(name string) bool { return set[name] }
+}
+
+// readPartial returns the source of the partial the provider calls name,
+// and the options to parse it with. The partials of a partial read by a
+// FileProvider are searched for in its directory first, as those of a
+// template parsed with ParseFile are.
+func (opts ParseOptions) readPartial(name string) (string, ParseOptions, error) {
+    fp, ok := opts.Partials.(*FileProvider)
+    if !ok {
+        data, err := opts.Partials.Get(name)
+        return data, opts, err
+    }
+    data, filename, err := fp.find(name)
+    if err == nil {
+        opts.Partials = fp.within(filepath.Dir(filename))
+    }
+    return data, opts, err
+}
+
+// partialName returns the name to ask the provider for the partial named
+// name in a tag.
+func (opts *ParseOptions) partialName(name string) string {
+    if opts.RewritePartialName == nil {
+        return name
+    }
+    return opts.RewritePartialName(name)
+}
+
+const (
+    DefaultMaxTagLength       = 64 * 1024
+    DefaultMaxDepth           = 100
+    DefaultMaxDelimiterLength = 32
+)
+
+// newTemplate returns a template to parse data with. Every template,
+// partials included, starts with the default delimiters, so that a set
+// delimiter tag in one never changes how another is parsed.
+func newTemplate(data []byte, opts ParseOptions) *Template {
+    tmpl := &Template{data: data, otag: "{{", ctag: "}}", curline: 1, col: 1, opts: opts, names: map[string]string{}}
+    if opts.Arena {
+        tmpl.arena = &arena{}
+    }
+    return tmpl
+}
+
 func ParseString(data string) (*Template, error) {
-    cwd := os.Getenv("CWD")
-    tmpl := Template{data, "{{", "}}", 0, 1, cwd, []interface{}{}}
+    return ParseStringOptions(data, ParseOptions{})
+}
+
+// ParseStringPartials parses a template, resolving its partials with the
+// given provider.
+func ParseStringPartials(data string, partials PartialProvider) (*Template, error) {
+    return ParseStringOptions(data, ParseOptions{Partials: partials})
+}
+
+// ParseStringOptions parses a template with the given options.
+func ParseStringOptions(data string, opts ParseOptions) (*Template, error) {
+    return parseBytes([]byte(data), opts)
+}
+
+func parseBytes(data []byte, opts ParseOptions) (*Template, error) {
+    if opts.Partials == nil {
+        cwd := os.Getenv("CWD")
+        opts.Partials = &FileProvider{Paths: []string{cwd, ""}}
+    }
+    if opts.MaxTagLength == 0 {
+        opts.MaxTagLength = DefaultMaxTagLength
+    }
+    if opts.MaxDepth == 0 {
+        opts.MaxDepth = DefaultMaxDepth
+    }
+    if opts.MaxDelimiterLength == 0 {
+        opts.MaxDelimiterLength = DefaultMaxDelimiterLength
+    }
+    tmpl := newTemplate(data, opts)
+    if opts.SpecStrict {
+        tmpl.included = make(map[string]*Template)
+    }
     err := tmpl.parse()
 
     if err != nil {
         return nil, err
     }
 
-    return &tmpl, err
+    return tmpl, nil
 }
 
 func ParseFile(filename string) (*Template, error) {
-    data, err := ioutil.ReadFile(filename)
+    return ParseFileOptions(filename, ParseOptions{})
+}
+
+// ParseFileOptions parses a template file with the given options.
+func ParseFileOptions(filename string, opts ParseOptions) (*Template, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    // stat the file that is read, so the two cannot disagree if the file is
+    // replaced meanwhile
+    info, err := f.Stat()
+    if err != nil {
+        return nil, err
+    }
+    data, err := ioutil.ReadAll(f)
     if err != nil {
         return nil, err
     }
 
-    dirname, _ := path.Split(filename)
-
-    tmpl := Template{string(data), "{{", "}}", 0, 1, dirname, []interface{}{}}
-    err = tmpl.parse()
+    if opts.Partials == nil {
+        dirname, _ := filepath.Split(filename)
+        opts.Partials = &FileProvider{Paths: []string{dirname, ""}}
+    }
 
+    tmpl, err := parseBytes(data, opts)
     if err != nil {
         return nil, err
     }
+    tmpl.filename = filename
+    tmpl.fileinfo = info
+    return tmpl, nil
+}
+
+// Filename returns the name of the file the template was parsed from, or ""
+// if it was not parsed from a file.
+func (tmpl *Template) Filename() string {
+    return tmpl.filename
+}
 
-    return &tmpl, nil
+// FileInfo describes the file the template was parsed from, as it was when
+// read, or is nil if the template was not parsed from a file. Caches can
+// compare its ModTime and Size with the file's current ones to tell whether
+// the template is stale.
+func (tmpl *Template) FileInfo() os.FileInfo {
+    return tmpl.fileinfo
 }
 
 func Render(data string, context ...interface{}) string {
@@ -565,6 +2281,26 @@ func Render(data string, context ...interface{}) string {
     return tmpl.Render(context...)
 }
 
+// RenderJSON parses a template and renders it with JSON-encoded data as the
+// context. See Template.RenderJSON.
+func RenderJSON(data string, jsonData []byte) (string, error) {
+    tmpl, err := ParseString(data)
+    if err != nil {
+        return "", err
+    }
+    return tmpl.RenderJSON(jsonData)
+}
+
+// RenderPartials is like Render, but resolves partials with the given
+// provider.
+func RenderPartials(data string, partials PartialProvider, context ...interface{}) string {
+    tmpl, err := ParseStringPartials(data, partials)
+    if err != nil {
+        return err.Error()
+    }
+    return tmpl.Render(context...)
+}
+
 func RenderInLayout(data string, layoutData string, context ...interface{}) string {
     layoutTmpl, err := ParseString(layoutData)
     if err != nil {
@@ -577,6 +2313,21 @@ func RenderInLayout(data string, layoutData string, context ...interface{}) stri
     return tmpl.RenderInLayout(layoutTmpl, context...)
 }
 
+// RenderInLayoutPartials is like RenderInLayout, but resolves the partials
+// of both the template and the layout with the given provider, so that a
+// partial named in the layout comes from the same place as the page's.
+func RenderInLayoutPartials(data string, layoutData string, partials PartialProvider, context ...interface{}) string {
+    layoutTmpl, err := ParseStringPartials(layoutData, partials)
+    if err != nil {
+        return err.Error()
+    }
+    tmpl, err := ParseStringPartials(data, partials)
+    if err != nil {
+        return err.Error()
+    }
+    return tmpl.RenderInLayout(layoutTmpl, context...)
+}
+
 func RenderFile(filename string, context ...interface{}) string {
     tmpl, err := ParseFile(filename)
     if err != nil {
@@ -597,3 +2348,20 @@ func RenderFileInLayout(filename string, layoutFile string, context ...interface
     }
     return tmpl.RenderInLayout(layoutTmpl, context...)
 }
+
+// RenderFileInLayoutPartials is like RenderFileInLayout, but resolves the
+// partials of both files with the given provider rather than looking for
+// them next to each file.
+func RenderFileInLayoutPartials(filename string, layoutFile string, partials PartialProvider, context ...interface{}) string {
+    opts := ParseOptions{Partials: partials}
+    layoutTmpl, err := ParseFileOptions(layoutFile, opts)
+    if err != nil {
+        return err.Error()
+    }
+
+    tmpl, err := ParseFileOptions(filename, opts)
+    if err != nil {
+        return err.Error()
+    }
+    return tmpl.RenderInLayout(layoutTmpl, context...)
+}
@@ -1,10 +1,24 @@
 package mustache
 
 import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log/slog"
     "os"
     "path"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "strconv"
     "strings"
     "testing"
+    "time"
+    "unsafe"
 )
 
 type Test struct {
@@ -83,11 +97,15 @@ var tests = []Test{
     {`hello {{name}}`, map[string]string{"name": "world"}, "hello world"},
     {`{{var}}`, map[string]string{"var": "5 > 2"}, "5 &gt; 2"},
     {`{{{var}}}`, map[string]string{"var": "5 > 2"}, "5 > 2"},
+    {`{{& var }}`, map[string]string{"var": "5 > 2"}, "5 > 2"},
+    {`{{var}}`, map[string]string{"var": "<a href=\"x\" title='&'>\x00"}, "&lt;a href=&#34;x&#34; title=&#39;&amp;&#39;&gt;\uFFFD"},
     {`{{a}}{{b}}{{c}}{{d}}`, map[string]string{"a": "a", "b": "b", "c": "c", "d": "d"}, "abcd"},
     {`0{{a}}1{{b}}23{{c}}456{{d}}89`, map[string]string{"a": "a", "b": "b", "c": "c", "d": "d"}, "0a1b23c456d89"},
     {`hello {{! comment }}world`, map[string]string{}, "hello world"},
     {`{{ a }}{{=<% %>=}}<%b %><%={{ }}=%>{{ c }}`, map[string]string{"a": "a", "b": "b", "c": "c"}, "abc"},
     {`{{ a }}{{= <% %> =}}<%b %><%= {{ }}=%>{{c}}`, map[string]string{"a": "a", "b": "b", "c": "c"}, "abc"},
+    {"{{=<%\t%>=}}<%a%><%=  {{ \t }}  =%>{{b}}", map[string]string{"a": "a", "b": "b"}, "ab"},
+    {"{{=\n<%\r\n%>\n=}}<%a%>", map[string]string{"a": "a"}, "a"},
 
     //does not exist
     {`{{dne}}`, map[string]string{"name": "world"}, ""},
@@ -222,11 +240,273 @@ func TestMultiContext(t *testing.T) {
     }
 }
 
+type DelimiterTest struct {
+    name     string
+    tmpl     string
+    expected string
+}
+
+var delimiterTests = []DelimiterTest{
+    {"Guillemets", `{{=« »=}}(«text»)`, "(Hey!)"},
+    {"Doubled guillemets", `{{=«« »»=}}(««text»»)`, "(Hey!)"},
+    {"Fullwidth braces", `{{=｛｛ ｝｝=}}(｛｛text｝｝)`, "(Hey!)"},
+    {"Mixed widths", `{{=<｛ ｝>=}}(<｛text｝>)`, "(Hey!)"},
+    {"Ideographic space separator", "{{=«\u3000»=}}(«text»)", "(Hey!)"},
+    {"Raw tag", `{{=« »=}}(«{html}»)`, "(<b>)"},
+    {"Escaped tag", `{{=« »=}}(«html»)`, "(&lt;b&gt;)"},
+    {"Sections", "{{=« »=}}[\n«#section»\n  «data»\n«/section»]", "[\n  I got interpolated.\n]"},
+    {"Inverted sections", `{{=« »=}}[«^none»«data»«/none»]`, "[I got interpolated.]"},
+    {"Comments", `{{=« »=}}(«! {{not}} a tag »)`, "()"},
+    {"Restore defaults", `{{=« »=}}«text» «={{ }}=»{{text}}`, "Hey! Hey!"},
+    {"Multi-byte text around tags", `{{=« »=}}ünïcödé «text» 日本語`, "ünïcödé Hey! 日本語"},
+}
+
+func TestDelimiters(t *testing.T) {
+    context := map[string]interface{}{
+        "text":    "Hey!",
+        "html":    "<b>",
+        "data":    "I got interpolated.",
+        "section": true,
+        "none":    false,
+    }
+    for _, test := range delimiterTests {
+        output := Render(test.tmpl, context)
+        if output != test.expected {
+            t.Errorf("%s: %q expected %q got %q", test.name, test.tmpl, test.expected, output)
+        }
+    }
+}
+
+func TestExecute(t *testing.T) {
+    var executor interface {
+        Execute(w io.Writer, data interface{}) error
+    }
+    executor, _ = ParseString(`hello {{name}}`)
+    var buf bytes.Buffer
+    if err := executor.Execute(&buf, map[string]string{"name": "world"}); err != nil {
+        t.Fatal(err)
+    }
+    if buf.String() != "hello world" {
+        t.Fatalf("expected %q got %q", "hello world", buf.String())
+    }
+}
+
+type flushRecorder struct {
+    bytes.Buffer
+    flushed []string
+}
+
+func (f *flushRecorder) Flush() {
+    f.flushed = append(f.flushed, f.String())
+}
+
+func TestFlush(t *testing.T) {
+    tmpl, _ := ParseString(`<head>{{title}}</head>{{#items}}<p>{{.}}</p>{{/items}}`)
+    context := map[string]interface{}{"title": "t", "items": []string{"a", "b"}}
+    tests := []struct {
+        opts    RenderOptions
+        flushed []string
+    }{
+        {RenderOptions{}, nil},
+        {RenderOptions{FlushText: true}, []string{"<head>", "<head>t</head>", "<head>t</head><p>", "<head>t</head><p>a</p>", "<head>t</head><p>a</p><p>", "<head>t</head><p>a</p><p>b</p>"}},
+        {RenderOptions{FlushThreshold: 10}, []string{"<head>t</head>", "<head>t</head><p>a</p><p>", "<head>t</head><p>a</p><p>b</p>"}},
+    }
+    for _, test := range tests {
+        var out flushRecorder
+        tmpl.FRenderOptions(&out, test.opts, context)
+        if strings.Join(out.flushed, "|") != strings.Join(test.flushed, "|") {
+            t.Fatalf("%+v expected flushes %q got %q", test.opts, test.flushed, out.flushed)
+        }
+    }
+}
+
+func TestYield(t *testing.T) {
+    src := `<head>{{title}}</head>
+{{!@head}}
+<body>{{#items}}{{.}}{{/items}}</body>{{! @body }}`
+    for _, minify := range []bool{false, true} {
+        tmpl, _ := ParseStringOptions(src, ParseOptions{Minify: minify})
+        context := map[string]interface{}{"title": "t", "items": []string{"a", "b"}}
+        var out flushRecorder
+        var yielded []string
+        yield := func(marker string) error {
+            yielded = append(yielded, marker+"="+out.String())
+            return nil
+        }
+        if err := tmpl.FRenderOptions(&out, RenderOptions{Yield: yield}, context); err != nil {
+            t.Fatal(err)
+        }
+        expected := []string{"head=<head>t</head>\n", "body=<head>t</head>\n\n<body>ab</body>"}
+        if strings.Join(yielded, "|") != strings.Join(expected, "|") {
+            t.Fatalf("expected yields %q got %q", expected, yielded)
+        }
+        if strings.Join(out.flushed, "|") != "<head>t</head>\n|<head>t</head>\n\n<body>ab</body>" {
+            t.Fatalf("unexpected flushes %q", out.flushed)
+        }
+
+        stop := errors.New("client went away")
+        out = flushRecorder{}
+        err := tmpl.FRenderOptions(&out, RenderOptions{Yield: func(string) error { return stop }}, context)
+        if err != stop || out.String() != "<head>t</head>\n" {
+            t.Fatalf("expected the render to stop, got %q, %v", out.String(), err)
+        }
+    }
+}
+
+// limitedWriter accepts up to n bytes, then fails, or if short is set
+// reports a short write without an error, against the io.Writer contract.
+type limitedWriter struct {
+    buf   bytes.Buffer
+    n     int
+    short bool
+    calls int
+}
+
+func (w *limitedWriter) String() string { return w.buf.String() }
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+    w.calls++
+    if len(p) <= w.n {
+        w.n -= len(p)
+        return w.buf.Write(p)
+    }
+    written, _ := w.buf.Write(p[:w.n])
+    w.n = 0
+    if w.short {
+        return written, nil
+    }
+    return written, errors.New("disk full")
+}
+
+func TestWriteErrors(t *testing.T) {
+    tmpl, _ := ParseString(`<p>{{a}}</p>{{#items}}<li>{{{.}}}</li>{{/items}}<end>`)
+    context := map[string]interface{}{"a": "<&>", "items": []string{"x", "y", "z"}}
+    tests := []struct {
+        n        int
+        short    bool
+        err      string
+        expected string
+        calls    int
+    }{
+        {4, false, "disk full", "<p>&", 2},
+        {14, false, "disk full", "<p>&lt;&amp;&g", 4},
+        {22, true, "short write", "<p>&lt;&amp;&gt;</p><l", 6},
+        {100, false, "", "<p>&lt;&amp;&gt;</p><li>x</li><li>y</li><li>z</li><end>", 15},
+    }
+    for _, test := range tests {
+        w := &limitedWriter{n: test.n, short: test.short}
+        err := tmpl.FRenderOptions(w, RenderOptions{BufferSize: -1}, context)
+        if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+            t.Fatalf("writing %d bytes: expected error %q got %v", test.n, test.err, err)
+        }
+        if w.String() != test.expected || w.calls != test.calls {
+            t.Fatalf("writing %d bytes: expected %q in %d writes, got %q in %d", test.n, test.expected, test.calls, w.String(), w.calls)
+        }
+    }
+}
+
+func TestBufferedWrites(t *testing.T) {
+    tmpl, _ := ParseString(`<ul>{{#items}}<li>{{.}}</li>{{/items}}</ul>`)
+    context := map[string]interface{}{"items": []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+    expected := "<ul><li>1</li><li>2</li><li>3</li><li>4</li><li>5</li><li>6</li><li>7</li><li>8</li><li>9</li><li>10</li></ul>"
+    tests := []struct {
+        size  int
+        calls int
+    }{
+        {0, 1},
+        {16, 7},
+        {-1, 32},
+    }
+    for _, test := range tests {
+        w := &limitedWriter{n: 1000}
+        if err := tmpl.FRenderOptions(w, RenderOptions{BufferSize: test.size}, context); err != nil {
+            t.Fatal(err)
+        }
+        if w.String() != expected || w.calls != test.calls {
+            t.Fatalf("buffer of %d: expected %q in %d writes, got %q in %d", test.size, expected, test.calls, w.String(), w.calls)
+        }
+    }
+
+    w := &limitedWriter{n: 10}
+    if err := tmpl.FRender(w, context); err == nil || err.Error() != "disk full" || w.String() != "<ul><li>1<" {
+        t.Fatalf("expected the buffered write to fail, got %q, %v", w.String(), err)
+    }
+}
+
 var malformed = []Test{
     {`{{#a}}{{}}{{/a}}`, Data{true, "hello"}, "empty tag"},
     {`{{}}`, nil, "empty tag"},
     {`{{}`, nil, "unmatched open tag"},
     {`{{`, nil, "unmatched open tag"},
+    {`{{=}}`, nil, "Invalid meta tag"},
+    {`{{==}}`, nil, "expected two delimiters"},
+    {`{{=<%=}}`, nil, "expected two delimiters"},
+    {`{{=<% %> %%=}}`, nil, "expected two delimiters"},
+    {`{{=<%= %>=}}`, nil, `Invalid delimiter "<%="`},
+    {"{{=\xab \xbb=}}", nil, `Invalid delimiter "\xab"`},
+    {`a {{{name}}`, nil, "line 1: malformed raw tag {{{name}} at column 3: missing closing brace"},
+    {"\n{{{name}} and {{{other}}}", nil, "line 2: malformed raw tag {{{name}} at column 1: missing closing brace"},
+    {`{{=<% %>=}}<%{name%> <%{b}%>`, nil, "malformed raw tag <%{name%> at column 12"},
+}
+
+func TestFileInfo(t *testing.T) {
+    filename := path.Join(os.Getenv("PWD"), "tests", "test1.mustache")
+    tmpl, err := ParseFile(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stat, _ := os.Stat(filename)
+    info := tmpl.FileInfo()
+    if tmpl.Filename() != filename || info == nil || info.Size() != stat.Size() || !info.ModTime().Equal(stat.ModTime()) {
+        t.Fatalf("unexpected file metadata %q %v", tmpl.Filename(), info)
+    }
+
+    tmpl, _ = ParseString("hello")
+    if tmpl.Filename() != "" || tmpl.FileInfo() != nil {
+        t.Fatal("expected no file metadata for a template parsed from a string")
+    }
+}
+
+type node struct{}
+
+func (n *node) Self() *node { return n }
+
+func TestContextDepth(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"node": "{{#Self}}.{{>node}}{{/Self}}"}}
+    tmpl, err := ParseStringOptions("{{>node}}", ParseOptions{Partials: partials, SpecStrict: true, MaxDepth: 1000})
+    if err != nil {
+        t.Fatal(err)
+    }
+    var buf bytes.Buffer
+    err = tmpl.FRenderOptions(&buf, RenderOptions{MaxContextDepth: 10}, &node{})
+    var lerr *LimitError
+    if !errors.As(err, &lerr) || lerr.Limit != "context depth" || lerr.Max != 10 {
+        t.Fatalf("expected a context depth limit error, got %v", err)
+    }
+    if buf.String() != "........." {
+        t.Fatalf("unexpected output %q", buf.String())
+    }
+
+    err = tmpl.FRender(ioutil.Discard, &node{})
+    if !errors.As(err, &lerr) || lerr.Max != DefaultMaxContextDepth {
+        t.Fatalf("expected a context depth limit error, got %v", err)
+    }
+}
+
+func TestUnknownSigils(t *testing.T) {
+    opts := ParseOptions{RejectUnknownSigils: true}
+    for _, tmpl := range []string{"{{name}}", "{{_name}}", "{{.}}", "{{0}}", "{{{<b>}}}", "{{&@x}}", "{{ #a }}{{/a}}"} {
+        if _, err := ParseStringOptions(tmpl, opts); err != nil {
+            t.Errorf("%q: unexpected error %v", tmpl, err)
+        }
+    }
+    _, err := ParseStringOptions("\n{{ @index }}", opts)
+    if err == nil || err.Error() != "line 2: unknown sigil '@' in tag {{@index}}" {
+        t.Fatalf("expected an unknown sigil error, got %v", err)
+    }
+    if _, err := ParseString("{{@index}}"); err != nil {
+        t.Fatalf("expected unknown sigils to be accepted by default, got %v", err)
+    }
 }
 
 func TestMalformed(t *testing.T) {
@@ -238,6 +518,1090 @@ func TestMalformed(t *testing.T) {
     }
 }
 
+func TestMergeText(t *testing.T) {
+    tests := []struct {
+        tmpl  string
+        elems int
+    }{
+        {``, 0},
+        {`{{a}}{{b}}`, 2},
+        {`a{{=<% %>=}}b<%={{ }}=%>c`, 1},
+        {`a{{a}}b{{#s}}{{/s}}`, 4},
+        {`a{{! comment }}b`, 3},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseString(test.tmpl)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(tmpl.elems) != test.elems {
+            t.Fatalf("%q expected %d elements got %d", test.tmpl, test.elems, len(tmpl.elems))
+        }
+    }
+    tmpl, _ := ParseStringOptions(`a{{! x }} {{! y }}b`, ParseOptions{Minify: true})
+    if len(tmpl.elems) != 1 || string(tmpl.elems[0].(*textElement).text) != "a b" {
+        t.Fatalf("expected minified text to merge into one element, got %v", tmpl.elems)
+    }
+}
+
+func TestMemoize(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "item":  "<b>{{! note }}item</b>",
+        "price": "{{price}}",
+    }}
+    tmpl, err := ParseStringPartials("{{#items}}<li>{{>item}}</li>{{/items}}{{#items}}{{>price}}{{/items}}", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if static := tmpl.elems[0].(*sectionElement).static; string(static) != "<li><b>item</b></li>" {
+        t.Fatalf("expected the first section to be memoized, got %q", static)
+    }
+    if static := tmpl.elems[1].(*sectionElement).static; static != nil {
+        t.Fatalf("expected the second section not to be memoized, got %q", static)
+    }
+    if tmpl.static != nil {
+        t.Fatalf("expected the template not to be memoized, got %q", tmpl.static)
+    }
+    items := []map[string]int{{"price": 1}, {"price": 2}}
+    if output := tmpl.Render(map[string]interface{}{"items": items}); output != "<li><b>item</b></li><li><b>item</b></li>12" {
+        t.Fatalf("unexpected output %q", output)
+    }
+
+    tmpl, _ = ParseStringPartials("a{{>item}}{{! note }}", partials)
+    if string(tmpl.static) != "a<b>item</b>" {
+        t.Fatalf("expected the template to be memoized, got %q", tmpl.static)
+    }
+}
+
+type result map[string]string
+
+func (r result) Error() string { return "method" }
+
+func TestPreferFields(t *testing.T) {
+    context := map[string]interface{}{"res": result{"Error": "key", "Code": "E1"}}
+    tmpl, _ := ParseString("{{#res}}{{Error}}{{/res}} {{res.Error}} {{res.Code}}")
+    if output := tmpl.Render(context); output != "method method E1" {
+        t.Fatalf("unexpected output %q", output)
+    }
+    var buf bytes.Buffer
+    tmpl.FRenderOptions(&buf, RenderOptions{PreferFields: true}, context)
+    if buf.String() != "key key E1" {
+        t.Fatalf("unexpected output %q", buf.String())
+    }
+}
+
+type counter struct {
+    N int
+}
+
+func (c *counter) Next() int {
+    c.N++
+    return c.N
+}
+
+func TestPointerMethods(t *testing.T) {
+    tmpl, _ := ParseString("{{#users}}{{Func2}}{{/users}} {{#user}}{{Func2}}{{/user}} {{#counters}}{{Next}}{{Next}}{{/counters}}")
+    counters := []counter{{0}, {10}}
+    context := map[string]interface{}{"users": []User{{"Mike", 1}}, "user": User{"Joe", 2}, "counters": counters}
+    if output := tmpl.Render(context); output != "  " {
+        t.Fatalf("unexpected output %q", output)
+    }
+
+    var buf bytes.Buffer
+    if err := tmpl.FRenderOptions(&buf, RenderOptions{PointerMethods: true}, context); err != nil {
+        t.Fatal(err)
+    }
+    if buf.String() != "Mike Joe 121112" {
+        t.Fatalf("unexpected output %q", buf.String())
+    }
+    // slice elements are addressable, so the methods changed them
+    if counters[0].N != 2 || counters[1].N != 12 {
+        t.Fatalf("expected the counters to be updated, got %v", counters)
+    }
+
+    // methods of embedded interfaces are promoted
+    embedded := struct{ fmt.Stringer }{celsius(20)}
+    if output := Render("{{String}}", embedded); output != "20.0°C" {
+        t.Fatalf("unexpected output %q", output)
+    }
+}
+
+func TestIsStatic(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"static": "<hr>{{! rule }}", "dynamic": "{{name}}"}}
+    tests := []struct {
+        tmpl   string
+        static bool
+    }{
+        {"", true},
+        {"<p>text</p>{{! note }}", true},
+        {"{{=<% %>=}}<p><%! note %></p>", true},
+        {"a{{>static}}b", true},
+        {"{{name}}", false},
+        {"{{#a}}text{{/a}}", false},
+        {"{{>dynamic}}", false},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.tmpl, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if tmpl.IsStatic() != test.static {
+            t.Errorf("%q: expected IsStatic to be %v", test.tmpl, test.static)
+        }
+    }
+
+    tmpl, _ := ParseStringPartials("<p>{{>static}}</p>", partials)
+    layout, _ := ParseString("<body>{{{content}}}</body>")
+    if output := tmpl.RenderInLayout(layout); output != "<body><p><hr></p></body>" {
+        t.Fatalf("unexpected output %q", output)
+    }
+}
+
+func TestSetEscape(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"title": "<h1>{{title}}</h1>"}}
+    tmpl, _ := ParseStringPartials(`{{>title}}<a title="{{title}}">{{{title}}}</a>`, partials)
+    context := map[string]string{"title": `"Tom" & 'Jerry'`}
+    expected := `<h1>&#34;Tom&#34; &amp; &#39;Jerry&#39;</h1><a title="&#34;Tom&#34; &amp; &#39;Jerry&#39;">"Tom" & 'Jerry'</a>`
+    if output := tmpl.Render(context); output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+    if EscapeHTML(context["title"]) != `&#34;Tom&#34; &amp; &#39;Jerry&#39;` {
+        t.Fatalf("unexpected escaping %q", EscapeHTML(context["title"]))
+    }
+
+    tmpl.SetEscape(strings.NewReplacer("&", "&amp;", `"`, "&quot;", "'", "&apos;", "<", "&lt;", ">", "&gt;").Replace)
+    expected = `<h1>&quot;Tom&quot; &amp; &apos;Jerry&apos;</h1><a title="&quot;Tom&quot; &amp; &apos;Jerry&apos;">"Tom" & 'Jerry'</a>`
+    if output := tmpl.Render(context); output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+}
+
+func TestIntrospection(t *testing.T) {
+    tmpl, _ := ParseString(`{{a}}{{=<% %>=}}<%b%>`)
+    if otag, ctag := tmpl.Delims(); otag != "<%" || ctag != "%>" {
+        t.Fatalf("unexpected delimiters %q %q", otag, ctag)
+    }
+    if opts := tmpl.Options(); opts.MaxDepth != DefaultMaxDepth || opts.Partials == nil || opts.SpecStrict {
+        t.Fatalf("unexpected options %+v", opts)
+    }
+    if output := tmpl.Escape()(`"`); output != "&#34;" {
+        t.Fatalf("expected %q got %q", "&#34;", output)
+    }
+
+    tmpl, _ = ParseStringOptions(`{{a}}`, ParseOptions{SpecStrict: true, MaxDepth: 5})
+    if otag, ctag := tmpl.Delims(); otag != "{{" || ctag != "}}" {
+        t.Fatalf("unexpected delimiters %q %q", otag, ctag)
+    }
+    if opts := tmpl.Options(); opts.MaxDepth != 5 || !opts.SpecStrict {
+        t.Fatalf("unexpected options %+v", opts)
+    }
+    if output := tmpl.Escape()(`"`); output != "&quot;" {
+        t.Fatalf("expected %q got %q", "&quot;", output)
+    }
+    tmpl.SetEscape(strings.ToUpper)
+    if output := tmpl.Escape()(`a`); output != "A" {
+        t.Fatalf("expected %q got %q", "A", output)
+    }
+}
+
+func TestRenderWithReport(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"footer": "{{year}} {{owner.name}}"}}
+    tmpl, _ := ParseStringPartials(`{{title}}: {{#items}}{{name}}={{price}} {{/items}}{{^flag}}{{title}}{{/flag}}{{>footer}}`, partials)
+    report, err := tmpl.RenderWithReport(map[string]interface{}{
+        "items": []map[string]string{{"name": "a"}, {"name": "b", "price": "2"}},
+        "year":  2024,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if expected := ": a= b=2 2024 "; report.Output != expected {
+        t.Fatalf("expected %q got %q", expected, report.Output)
+    }
+    if expected := []string{"title", "price", "owner.name"}; !reflect.DeepEqual(report.Missing, expected) {
+        t.Fatalf("expected %q got %q", expected, report.Missing)
+    }
+
+    report, err = tmpl.RenderWithReport(map[string]interface{}{"title": "T", "items": nil, "year": 1, "owner": map[string]string{"name": "me"}})
+    if err != nil || report.Output != "T: T1 me" || report.Missing != nil {
+        t.Fatalf("unexpected report %+v, %v", report, err)
+    }
+}
+
+func TestPlaceholder(t *testing.T) {
+    tmpl, _ := ParseString(`{{greeting}}, {{{name}}}{{#user}} ({{user.email}}){{/user}}`)
+    placeholder := func(name string) string { return "⟦" + name + "⟧" }
+    tests := []struct {
+        context  interface{}
+        expected string
+    }{
+        {nil, "⟦greeting⟧, ⟦name⟧"},
+        {map[string]interface{}{"greeting": "Hi", "name": "", "user": map[string]string{}}, "Hi,  (⟦user.email⟧)"},
+        {map[string]interface{}{"greeting": "Hi", "name": "<b>", "user": map[string]string{"email": "a@b"}}, "Hi, <b> (a@b)"},
+    }
+    for _, test := range tests {
+        var buf bytes.Buffer
+        if err := tmpl.FRenderOptions(&buf, RenderOptions{Placeholder: placeholder}, test.context); err != nil {
+            t.Fatal(err)
+        }
+        if buf.String() != test.expected {
+            t.Fatalf("expected %q got %q", test.expected, buf.String())
+        }
+    }
+}
+
+func TestMaxValueLength(t *testing.T) {
+    tests := []struct {
+        max      int
+        value    interface{}
+        expected string
+    }{
+        {5, "hello", "hello"},
+        {5, "hello world", "hell…"},
+        {5, "héllo wörld", "héll…"},
+        {1, "hello", "…"},
+        {3, 123456, "12…"},
+        {4, "<b>bold</b>", "&lt;b&gt;…"},
+        {0, "hello world", "hello world"},
+    }
+    tmpl, _ := ParseString(`{{value}}`)
+    for _, test := range tests {
+        var buf bytes.Buffer
+        err := tmpl.FRenderOptions(&buf, RenderOptions{MaxValueLength: test.max}, map[string]interface{}{"value": test.value})
+        if err != nil || buf.String() != test.expected {
+            t.Fatalf("%v cut to %d: expected %q got %q, %v", test.value, test.max, test.expected, buf.String(), err)
+        }
+    }
+}
+
+type panicky struct{}
+
+func (panicky) Boom() string { panic("boom") }
+
+func TestLogger(t *testing.T) {
+    var logs bytes.Buffer
+    logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+        ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+            if a.Key == slog.TimeKey {
+                return slog.Attr{}
+            }
+            return a
+        },
+    }))
+    tmpl, _ := ParseString(`a{{Boom}}b`)
+    var buf bytes.Buffer
+    if err := tmpl.FRenderOptions(&buf, RenderOptions{Logger: logger}, panicky{}); err != nil {
+        t.Fatal(err)
+    }
+    if buf.String() != "ab" {
+        t.Fatalf("expected %q got %q", "ab", buf.String())
+    }
+    expected := "level=WARN msg=\"mustache: panic while looking up a name\" name=Boom panic=boom\n"
+    if logs.String() != expected {
+        t.Fatalf("expected %q got %q", expected, logs.String())
+    }
+}
+
+type page struct {
+    all    []string
+    offset int
+    size   int
+}
+
+func (p *page) MustacheItems() []interface{} {
+    var items []interface{}
+    for i := p.offset; i < len(p.all) && i < p.offset+p.size; i++ {
+        items = append(items, map[string]string{"name": p.all[i]})
+    }
+    return items
+}
+
+func TestSectionIterable(t *testing.T) {
+    all := []string{"a", "b", "c", "d", "e"}
+    tests := []struct {
+        context  interface{}
+        expected string
+    }{
+        {map[string]interface{}{"results": &page{all, 1, 2}}, "<b><c>"},
+        {map[string]interface{}{"results": &page{all, 4, 2}}, "<e>"},
+        {map[string]interface{}{"results": &page{all, 5, 2}}, "none"},
+        {map[string]interface{}{"results": SectionIterable(&page{all, 0, 1})}, "<a>"},
+        {map[string]interface{}{"results": (*page)(nil)}, "none"},
+        {map[string]interface{}{"results": page{all, 0, 1}}, "<>"},
+    }
+    for _, test := range tests {
+        output := Render(`{{#results}}<{{name}}>{{/results}}{{^results}}none{{/results}}`, test.context)
+        if output != test.expected {
+            t.Fatalf("expected %q got %q", test.expected, output)
+        }
+    }
+}
+
+func TestPointerSections(t *testing.T) {
+    list := []string{"a", "b"}
+    plist := &list
+    pplist := &plist
+    var nilList *[]string
+    name := "x"
+    tests := []struct {
+        value    interface{}
+        expected string
+    }{
+        {list, "(a)(b)"},
+        {plist, "(a)(b)"},
+        {pplist, "(a)(b)"},
+        {interface{}(plist), "(a)(b)"},
+        {[]interface{}{plist}, "([a b])"},
+        {&[2]string{"a", "b"}, "(a)(b)"},
+        {[0]string{}, "empty"},
+        {nilList, "empty"},
+        {&[]string{}, "empty"},
+        {[]*string{&name, &name}, "(x)(x)"},
+        {[]**string{&[]*string{&name}[0]}, "(x)"},
+    }
+    tmpl, _ := ParseString(`{{#v}}({{.}}){{/v}}{{^v}}empty{{/v}}`)
+    for _, test := range tests {
+        if output := tmpl.Render(map[string]interface{}{"v": test.value}); output != test.expected {
+            t.Fatalf("%T: expected %q got %q", test.value, test.expected, output)
+        }
+    }
+
+    nested := []*[]string{plist, {"c"}}
+    output := Render(`{{#v}}[{{#.}}({{.}}){{/.}}]{{/v}}`, map[string]interface{}{"v": nested})
+    if expected := "[(a)(b)][(c)]"; output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+}
+
+type Base struct{ ID int }
+
+type account struct {
+    Name     string
+    password string
+    *Base
+}
+
+func TestUnexportedFields(t *testing.T) {
+    tests := []struct {
+        tmpl     string
+        context  interface{}
+        expected string
+    }{
+        {`{{Name}}:{{password}}`, account{Name: "bob", password: "secret"}, "bob:"},
+        {`{{Name}}:{{#password}}set{{/password}}`, &account{Name: "bob", password: "secret"}, "bob:"},
+        {`{{ID}}`, account{Base: &Base{7}}, "7"},
+        {`{{ID}}`, account{}, ""},
+    }
+    for _, test := range tests {
+        if output := Render(test.tmpl, test.context); output != test.expected {
+            t.Fatalf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+        tmpl, _ := ParseString(test.tmpl)
+        var buf bytes.Buffer
+        if err := tmpl.FRenderOptions(&buf, RenderOptions{PreferFields: true}, test.context); err != nil || buf.String() != test.expected {
+            t.Fatalf("%q with PreferFields expected %q got %q, %v", test.tmpl, test.expected, buf.String(), err)
+        }
+    }
+
+    tmpl, _ := ParseStringOptions(`{{Name}}:{{password}}`, ParseOptions{SpecStrict: true})
+    err := tmpl.FRender(ioutil.Discard, account{Name: "bob"})
+    if expected := "field password of mustache.account is unexported"; err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+}
+
+func TestRenderComments(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"nav": "{{! nav.mustache }}<nav>"}}
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{! header }}<h1>{{title}}</h1>`, "<!-- header --><h1>Hi</h1>"},
+        {`{{>nav}}{{! a -- b --- c }}`, "<!-- nav.mustache --><nav><!-- a - - b - - - c -->"},
+        {`static{{!note}}`, "static<!-- note -->"},
+        {`{{#s}}{{!in section}}{{/s}}`, "<!-- in section -->"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.tmpl, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var buf bytes.Buffer
+        if err := tmpl.FRenderOptions(&buf, RenderOptions{Comments: true}, map[string]interface{}{"title": "Hi", "s": true}); err != nil {
+            t.Fatal(err)
+        }
+        if buf.String() != test.expected {
+            t.Fatalf("%q expected %q got %q", test.tmpl, test.expected, buf.String())
+        }
+        if output := tmpl.Render(); strings.Contains(output, "<!--") {
+            t.Fatalf("%q rendered comments by default: %q", test.tmpl, output)
+        }
+    }
+}
+
+func TestContextPrecedence(t *testing.T) {
+    site := map[string]interface{}{"title": "Site", "user": map[string]interface{}{"name": "guest", "email": "none"}}
+    page := map[string]interface{}{"title": "Page", "user": map[string]string{"name": "bob"}}
+    tests := []struct {
+        opts     RenderOptions
+        expected string
+    }{
+        {RenderOptions{}, "Page bob  Site"},
+        {RenderOptions{LastContextWins: true}, "Site guest none Site"},
+        {RenderOptions{MergeContexts: true}, "Page bob none Site"},
+        {RenderOptions{MergeContexts: true, LastContextWins: true}, "Site guest none Site"},
+    }
+    tmpl, _ := ParseString(`{{title}} {{user.name}} {{user.email}} {{Title}}`)
+    for _, test := range tests {
+        var buf bytes.Buffer
+        if err := tmpl.FRenderOptions(&buf, test.opts, page, site, struct{ Title string }{"Site"}); err != nil {
+            t.Fatal(err)
+        }
+        if buf.String() != test.expected {
+            t.Fatalf("%+v: expected %q got %q", test.opts, test.expected, buf.String())
+        }
+    }
+    if _, ok := page["user"].(map[string]string); !ok || site["user"].(map[string]interface{})["name"] != "guest" {
+        t.Fatal("merging changed the contexts")
+    }
+}
+
+func TestPreparedContext(t *testing.T) {
+    tmpl, _ := ParseString(`{{title}}:{{#items}}{{name}}{{title}},{{/items}}`)
+    c := NewContext(map[string]interface{}{"title": "A"}, map[string]interface{}{
+        "title": "B",
+        "items": []map[string]string{{"name": "x"}, {"name": "y", "title": "Y"}},
+    })
+    tests := []struct {
+        push     interface{}
+        pop      bool
+        expected string
+    }{
+        {nil, false, "A:xA,yY,"},
+        {map[string]string{"title": "C"}, false, "C:xC,yY,"},
+        {nil, true, "A:xA,yY,"},
+    }
+    for _, test := range tests {
+        if test.push != nil {
+            c.Push(test.push)
+        }
+        if test.pop {
+            c.Pop()
+        }
+        var buf bytes.Buffer
+        if err := tmpl.FRenderContext(&buf, RenderOptions{}, c); err != nil {
+            t.Fatal(err)
+        }
+        if buf.String() != test.expected {
+            t.Fatalf("expected %q got %q", test.expected, buf.String())
+        }
+    }
+    if c.Len() != 2 {
+        t.Fatalf("expected 2 values, got %d", c.Len())
+    }
+
+    // renders sharing a context must not see each other's sections
+    c.Push(map[string]string{"title": "D"})
+    c.Pop()
+    done := make(chan string)
+    for i := 0; i < 4; i++ {
+        go func() {
+            var buf bytes.Buffer
+            tmpl.FRenderContext(&buf, RenderOptions{}, c)
+            done <- buf.String()
+        }()
+    }
+    for i := 0; i < 4; i++ {
+        if output := <-done; output != "A:xA,yY," {
+            t.Fatalf("expected %q got %q", "A:xA,yY,", output)
+        }
+    }
+    c.Pop()
+    c.Pop()
+    c.Pop()
+    var buf bytes.Buffer
+    if err := tmpl.FRenderContext(&buf, RenderOptions{}, c); err != nil || buf.String() != ":" || c.Len() != 0 {
+        t.Fatalf("unexpected output %q or length %d, %v", buf.String(), c.Len(), err)
+    }
+}
+
+func TestRawOption(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"body": "{{body_html}}|{{title}}"}}
+    data := map[string]string{"body_html": "<p>hi</p>", "title": "a & b", "other_html": "<b>"}
+    tests := []struct {
+        raw      func(string) bool
+        expected string
+    }{
+        {nil, "&lt;p&gt;hi&lt;/p&gt;|a &amp; b|&lt;b&gt;|<b>"},
+        {RawNames("body_html"), "<p>hi</p>|a &amp; b|&lt;b&gt;|<b>"},
+        {func(name string) bool { return strings.HasSuffix(name, "_html") }, "<p>hi</p>|a &amp; b|<b>|<b>"},
+    }
+    for i, test := range tests {
+        tmpl, err := ParseStringOptions("{{>body}}|{{other_html}}|{{{other_html}}}", ParseOptions{Partials: partials, Raw: test.raw})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if output := tmpl.Render(data); output != test.expected {
+            t.Errorf("%d: expected %q got %q", i, test.expected, output)
+        }
+    }
+}
+
+type reading float64
+
+func (c *reading) String() string { return strconv.FormatFloat(float64(*c), 'f', 1, 64) + "C" }
+
+func TestFormatVerbs(t *testing.T) {
+    price := 3.14159
+    temp := reading(21.5)
+    data := map[string]interface{}{"price": &price, "id": 42, "name": "<b>", "user": map[string]int{"age": 7}, "temp": &temp}
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{price:%06.2f}}`, "003.14"},
+        {`{{ id :%05d}}|{{id:%x}}`, "00042|2a"},
+        {`{{name:%q}}`, "&#34;&lt;b&gt;&#34;"},
+        {`{{{name:%q}}}|{{&name:%-5s}}|`, "\"<b>\"|<b>  |"},
+        {`{{user.age:%03d}}`, "007"},
+        {`{{#user}}{{age:%+d}}{{/user}}`, "+7"},
+        {`{{missing:%d}}`, ""},
+        {`{{temp:%s}}|{{temp:%.2v}}|{{temp}}`, "21.5C|21|21.5C"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringOptions(test.tmpl, ParseOptions{FormatVerbs: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if output := tmpl.Render(data); output != test.expected {
+            t.Errorf("%s: expected %q got %q", test.tmpl, test.expected, output)
+        }
+        if unparsed, _ := ParseStringOptions(tmpl.Unparse(), ParseOptions{FormatVerbs: true}); !unparsed.Equal(tmpl) {
+            t.Errorf("%s: expected the verb to survive Unparse, got %s", test.tmpl, tmpl.Unparse())
+        }
+    }
+
+    // without the option, the verb is part of the name
+    if output := Render(`{{id:%05d}}`, map[string]interface{}{"id:%05d": "x"}); output != "x" {
+        t.Fatalf("expected the whole tag to be the name, got %q", output)
+    }
+}
+
+func TestDebugFooter(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"header": "<h1>{{>title}}</h1>", "title": "--{{name}}--", "footer": "."}}
+    tmpl, err := ParseStringPartials(`{{>header}}{{#items}}{{>footer}}{{/items}}`, partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var sb strings.Builder
+    err = tmpl.FRenderOptions(&sb, RenderOptions{Debug: true}, map[string]interface{}{"name": "x", "items": []int{1, 2}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := regexp.MustCompile(`^<h1>--x--</h1>\.\.<!-- mustache \{"hash":"` + tmpl.Hash() +
+        `","partials":\["header","title","footer"\],"duration":"[0-9.]+[µnm]?s"\} -->$`)
+    if !expected.MatchString(sb.String()) {
+        t.Fatalf("unexpected output %q", sb.String())
+    }
+
+    filename := path.Join(os.Getenv("PWD"), "tests", "test1.mustache")
+    tmpl, err = ParseFile(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sb.Reset()
+    tmpl.FRenderOptions(&sb, RenderOptions{Debug: true})
+    if !strings.Contains(sb.String(), `<!-- mustache {"template":"test1.mustache","hash"`) {
+        t.Fatalf("expected the file's name in %q", sb.String())
+    }
+}
+
+func TestRenderWithFallback(t *testing.T) {
+    failing := func() (string, error) { return "", errors.New("no stock") }
+    primary, _ := ParseString(`<h1>{{title}}</h1>{{stock}}`)
+    primary.SetDefaults(map[string]interface{}{"stock": failing})
+    fallback, _ := ParseString(`Sorry, {{title}} is unavailable`)
+
+    var buf bytes.Buffer
+    err := RenderWithFallback(&buf, primary, fallback, map[string]interface{}{"title": "Shop", "stock": 3})
+    if err != nil || buf.String() != "<h1>Shop</h1>3" {
+        t.Fatalf("expected the primary output, got %q, %v", buf.String(), err)
+    }
+
+    buf.Reset()
+    err = RenderWithFallback(&buf, primary, fallback, map[string]interface{}{"title": "Shop"})
+    if buf.String() != "Sorry, Shop is unavailable" {
+        t.Fatalf("expected only the fallback output, got %q", buf.String())
+    }
+    var ferr *FallbackError
+    if !errors.As(err, &ferr) || ferr.Err.Error() != "line 1: calling stock: no stock" {
+        t.Fatalf("expected a FallbackError, got %v", err)
+    }
+
+    broken, _ := ParseString(`{{stock}}`)
+    broken.SetDefaults(map[string]interface{}{"stock": failing})
+    buf.Reset()
+    err = RenderWithFallback(&buf, primary, broken, map[string]interface{}{})
+    if err == nil || err.Error() != "line 1: calling stock: no stock" {
+        t.Fatalf("expected the fallback's error, got %v", err)
+    }
+}
+
+func TestRenderTo(t *testing.T) {
+    var sb strings.Builder
+    sb.WriteString("list:")
+    tmpl, _ := ParseString(`{{#items}} {{.}}{{/items}}`)
+    if err := tmpl.RenderTo(&sb, map[string]interface{}{"items": []string{"a", "<b>"}}); err != nil {
+        t.Fatal(err)
+    }
+    static, _ := ParseString(`;`)
+    static.RenderTo(&sb)
+    tmpl, _ = ParseString(`{{fail}}`)
+    tmpl.SetDefaults(map[string]interface{}{"fail": func() (string, error) { return "", errors.New("failed") }})
+    err := tmpl.RenderTo(&sb)
+    if err == nil || err.Error() != "line 1: calling fail: failed" {
+        t.Fatalf("expected the helper's error, got %v", err)
+    }
+    if expected := "list: a &lt;b&gt;;"; sb.String() != expected {
+        t.Fatalf("expected %q got %q", expected, sb.String())
+    }
+}
+
+func TestRedact(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"creds": "{{login}}/{{password}}"}}
+    tmpl, _ := ParseStringPartials(`{{token}} {{#users}}{{>creds}};{{/users}} {{{apiKey}}} {{^users}}{{password}}{{/users}}`, partials)
+    context := map[string]interface{}{
+        "token":    "t0k3n",
+        "apiKey":   "<key>",
+        "password": "root",
+        "users":    []map[string]string{{"login": "ann", "password": "pw1"}, {"login": "bob", "password": "pw2"}},
+    }
+    var paths []string
+    redact := RedactMatching(regexp.MustCompile(`(?i)(password|token|key)$`))
+    record := func(path, value string) string {
+        paths = append(paths, path)
+        return redact(path, value)
+    }
+    var buf bytes.Buffer
+    if err := tmpl.FRenderOptions(&buf, RenderOptions{Redact: record}, context); err != nil {
+        t.Fatal(err)
+    }
+    if expected := "[REDACTED] ann/[REDACTED];bob/[REDACTED]; [REDACTED] "; buf.String() != expected {
+        t.Fatalf("expected %q got %q", expected, buf.String())
+    }
+    expected := []string{"token", "users.login", "users.password", "users.login", "users.password", "apiKey"}
+    if !reflect.DeepEqual(paths, expected) {
+        t.Fatalf("expected paths %q got %q", expected, paths)
+    }
+}
+
+type clock struct{}
+
+func (clock) Year(ctx context.Context) int { return Now(ctx).Year() }
+
+func TestReproducible(t *testing.T) {
+    tmpl, _ := ParseString(`{{Year}} {{today}} {{since start}} {{n}}`)
+    tmpl.SetDefaults(map[string]interface{}{
+        "today": func(ctx context.Context) string { return Now(ctx).Format("2006-01-02") },
+    })
+    start := time.Date(1999, time.December, 31, 0, 0, 0, 0, time.UTC)
+    context := map[string]interface{}{
+        "since": func(ctx context.Context, t time.Time) time.Duration { return Now(ctx).Sub(t) },
+        "start": start,
+        "n":     1.5,
+    }
+    tests := []struct {
+        opts     RenderOptions
+        expected string
+    }{
+        {RenderOptions{Reproducible: true}, "2000 2000-01-01 24h0m0s 1.5"},
+        {RenderOptions{Now: func() time.Time { return start.Add(time.Hour) }}, "1999 1999-12-31 1h0m0s 1.5"},
+    }
+    for _, test := range tests {
+        var buf bytes.Buffer
+        if err := tmpl.FRenderOptions(&buf, test.opts, clock{}, context); err != nil {
+            t.Fatal(err)
+        }
+        if buf.String() != test.expected {
+            t.Fatalf("expected %q got %q", test.expected, buf.String())
+        }
+    }
+    if output := tmpl.Render(clock{}, context); !strings.HasPrefix(output, strconv.Itoa(time.Now().Year())) {
+        t.Fatalf("expected the current year, got %q", output)
+    }
+
+    tmpl, _ = ParseString(`{{ch}}`)
+    err := tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Reproducible: true}, map[string]interface{}{"ch": make(chan int)})
+    if err == nil || err.Error() != "line 1: ch renders as a memory address" {
+        t.Fatalf("expected an error, got %v", err)
+    }
+}
+
+func TestDefaults(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"footer": "(c) {{year}} {{site}}"}}
+    tmpl, err := ParseStringPartials("{{#page}}{{title}} - {{site}}{{/page}}{{#admin}}!{{/admin}}\n{{>footer}}", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl.SetDefaults(map[string]interface{}{"site": "Example", "year": 2024, "admin": true})
+
+    tests := []struct {
+        context  interface{}
+        expected string
+    }{
+        {map[string]interface{}{"page": map[string]string{"title": "Home"}}, "Home - Example!\n(c) 2024 Example"},
+        {map[string]interface{}{"page": map[string]string{"title": "Home", "site": "Page"}, "admin": false}, "Home - Page\n(c) 2024 Example"},
+        {map[string]interface{}{"site": "Override", "year": 2025}, "!\n(c) 2025 Override"},
+        {nil, "!\n(c) 2024 Example"},
+    }
+    for _, test := range tests {
+        if output := tmpl.Render(test.context); output != test.expected {
+            t.Errorf("expected %q got %q", test.expected, output)
+        }
+    }
+}
+
+func TestRenderJSON(t *testing.T) {
+    tests := []struct {
+        tmpl     string
+        json     string
+        expected string
+    }{
+        {`{{name}} is {{age}}`, `{"name": "Ann", "age": 3}`, "Ann is 3"},
+        {`{{price}} {{id}}`, `{"price": 1.21, "id": 12345678901234567890}`, "1.21 12345678901234567890"},
+        {`{{#items}}<{{.}}>{{/items}}{{^none}}none{{/none}}`, `{"items": ["a", "b"], "none": []}`, "<a><b>none"},
+        {`{{#ok}}yes{{/ok}}{{^missing}}no{{/missing}}`, `{"ok": true, "missing": null}`, "yesno"},
+        {`{{a.b}}`, `{"a": {"b": "&"}}`, "&amp;"},
+    }
+    for _, test := range tests {
+        output, err := RenderJSON(test.tmpl, []byte(test.json))
+        if err != nil {
+            t.Fatalf("%q: %v", test.tmpl, err)
+        }
+        if output != test.expected {
+            t.Errorf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+
+    if _, err := RenderJSON("{{a}}", []byte(`{"a":`)); err == nil {
+        t.Fatal("expected an error for invalid JSON")
+    }
+    if _, err := RenderJSON("{{#a}}", []byte(`{}`)); err == nil {
+        t.Fatal("expected an error for an invalid template")
+    }
+}
+
+type celsius float64
+
+func (c celsius) String() string { return fmt.Sprintf("%.1f°C", float64(c)) }
+
+func TestNumbers(t *testing.T) {
+    context := map[string]interface{}{
+        "int":     3,
+        "float":   float64(3),
+        "decimal": 1.21,
+        "large":   123456789.0,
+        "small":   0.00001,
+        "single":  float32(0.1),
+        "temp":    celsius(21),
+    }
+    tmpl, _ := ParseString("{{int}} {{float}} {{decimal}} {{large}} {{small}} {{single}} {{temp}}")
+    expected := "3 3 1.21 123456789 0.00001 0.1 21.0°C"
+    if output := tmpl.Render(context); output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+
+    var buf bytes.Buffer
+    opts := RenderOptions{FormatFloat: func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) }}
+    if err := tmpl.FRenderOptions(&buf, opts, context); err != nil {
+        t.Fatal(err)
+    }
+    expected = "3 3.00 1.21 123456789.00 0.00 0.10 21.0°C"
+    if buf.String() != expected {
+        t.Fatalf("expected %q got %q", expected, buf.String())
+    }
+}
+
+func TestPositions(t *testing.T) {
+    tmpl, err := ParseString("a {{x}}\n{{#s}}\n  {{! note }}{{{y}}}\n{{/s}}«{{=| |=}}|z|")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []pos
+    var walk func(elems []interface{})
+    walk = func(elems []interface{}) {
+        for _, elem := range elems {
+            switch elem := elem.(type) {
+            case *varElement:
+                got = append(got, elem.pos)
+            case *commentElement:
+                got = append(got, elem.pos)
+            case *sectionElement:
+                got = append(got, elem.pos)
+                walk(elem.elems)
+            }
+        }
+    }
+    walk(tmpl.elems)
+    expected := []pos{{1, 3}, {2, 1}, {3, 3}, {3, 14}, {4, 17}}
+    if len(got) != len(expected) {
+        t.Fatalf("expected positions %v got %v", expected, got)
+    }
+    for i := range expected {
+        if got[i] != expected[i] {
+            t.Fatalf("expected positions %v got %v", expected, got)
+        }
+    }
+
+    _, err = ParseString("{{#a}}\n\n{{/b}}")
+    if err == nil || err.Error() != "line 3: interleaved closing tag: b" {
+        t.Fatalf("expected error on line 3, got %v", err)
+    }
+}
+
+var minifyTests = []Test{
+    {"<ul>{{! items }}\n  {{#items}}\n    {{.}}\n  {{/items}}</ul>", map[string]interface{}{"items": []string{"a", "b"}}, "<ul>\n a\n b\n</ul>"},
+    {"{{a}}  {{! x }}  {{b}}", map[string]string{"a": "a", "b": "b"}, "a b"},
+    {"{{a}} {{!x}}\t\n{{b}}", map[string]string{"a": "a", "b": "b"}, "a\nb"},
+    {"hello  world {{a}}", map[string]string{"a": "a"}, "hello  world a"},
+}
+
+func TestMinify(t *testing.T) {
+    for _, test := range minifyTests {
+        tmpl, err := ParseStringOptions(test.tmpl, ParseOptions{Minify: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        output := tmpl.Render(test.context)
+        if output != test.expected {
+            t.Fatalf("%q expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+}
+
+func TestLimits(t *testing.T) {
+    deep := strings.Repeat("{{#a}}", 5) + strings.Repeat("{{/a}}", 5)
+    recursive := &StaticProvider{map[string]string{"self": "{{>self}}"}}
+    tests := []struct {
+        tmpl  string
+        opts  ParseOptions
+        limit string
+    }{
+        {"{{" + strings.Repeat("a", DefaultMaxTagLength) + "}}", ParseOptions{}, "tag length"},
+        {"\n{{! " + strings.Repeat("a", 10) + " }}", ParseOptions{MaxTagLength: 10}, "tag length"},
+        {deep, ParseOptions{MaxDepth: 4}, "nesting depth"},
+        {strings.Repeat("{{#a}}", DefaultMaxDepth+1), ParseOptions{}, "nesting depth"},
+        {"{{>self}}", ParseOptions{Partials: recursive}, "nesting depth"},
+        {"{{=" + strings.Repeat("<", 33) + " >=}}", ParseOptions{}, "delimiter length"},
+    }
+    for _, test := range tests {
+        _, err := ParseStringOptions(test.tmpl, test.opts)
+        var lerr *LimitError
+        if !errors.As(err, &lerr) || lerr.Limit != test.limit {
+            t.Fatalf("%.40q expected a %s limit error, got %v", test.tmpl, test.limit, err)
+        }
+    }
+    if _, err := ParseStringOptions(deep, ParseOptions{MaxDepth: 5}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func FuzzParseString(f *testing.F) {
+    for _, test := range tests {
+        f.Add(test.tmpl)
+    }
+    for _, test := range malformed {
+        f.Add(test.tmpl)
+    }
+    f.Fuzz(func(t *testing.T, data string) {
+        tmpl, err := ParseStringPartials(data, &StaticProvider{})
+        if err != nil {
+            switch err.(type) {
+            case parseError, *LimitError, *PartialError:
+            default:
+                if !strings.HasPrefix(err.Error(), "Could not find partial") {
+                    t.Fatalf("%q: unexpected error %v", data, err)
+                }
+            }
+            return
+        }
+        tmpl.Render(map[string]interface{}{})
+
+        // standalone lines are only handled in SpecStrict mode
+        partials := &StaticProvider{map[string]string{"p": data}}
+        tmpl, err = ParseStringOptions(data, ParseOptions{Partials: partials, SpecStrict: true})
+        if err == nil {
+            tmpl.Render(map[string]interface{}{})
+        }
+    })
+}
+
+type panickyWriter struct{}
+
+func (panickyWriter) Write(p []byte) (int, error) { panic("write") }
+
+func TestNoPanic(t *testing.T) {
+    tests := []struct {
+        tmpl     string
+        out      io.Writer
+        expected string
+    }{
+        {`a{{Boom}}b`, ioutil.Discard, `panic while looking up "Boom": boom`},
+        {`{{#Boom}}x{{/Boom}}`, ioutil.Discard, `panic while looking up "Boom": boom`},
+        {`{{helper}}`, ioutil.Discard, `panic while looking up "helper": helper`},
+        {`text`, panickyWriter{}, `panic while rendering: write`},
+    }
+    helpers := map[string]interface{}{"helper": func() string { panic("helper") }}
+    for _, test := range tests {
+        tmpl, err := ParseString(test.tmpl)
+        if err != nil {
+            t.Fatal(err)
+        }
+        tmpl.SetDefaults(helpers)
+        err = tmpl.FRenderOptions(test.out, RenderOptions{NoPanic: true}, panicky{})
+        var perr *PanicError
+        if !errors.As(err, &perr) || err.Error() != test.expected || len(perr.Stack) == 0 {
+            t.Errorf("%s: expected %q got %v", test.tmpl, test.expected, err)
+        }
+    }
+}
+
+// noPanicData holds the awkward shapes of data a render must cope with.
+type noPanicData struct {
+    Nil       *noPanicData
+    Map       map[string]interface{}
+    Func      func() string
+    Chan      chan int
+    Iface     interface{}
+    Error     error
+    Slice     []*int
+    Array     [2]*string
+    Ptrs      **int
+    unexposed int
+    *Embedded
+}
+
+type Embedded struct {
+    Inner string
+}
+
+func FuzzRenderNoPanic(f *testing.F) {
+    for _, test := range tests {
+        f.Add(test.tmpl, `{"a": [1, {"b": null}], "c": "<x>"}`)
+    }
+    f.Add(`{{#Nil}}{{Map.x.y}}{{/Nil}}{{Inner}}{{#Slice}}{{.}}{{/Slice}}{{Array}}{{Ptrs}}{{#Func}}{{/Func}}`, `[]`)
+    f.Add(`{{#Chan}}{{.}}{{/Chan}}{{Iface.x}}{{Error}}{{unexposed}}{{#Embedded}}{{Inner}}{{/Embedded}}`, `null`)
+    f.Fuzz(func(t *testing.T, src, jsonData string) {
+        tmpl, err := ParseStringOptions(src, ParseOptions{Partials: &StaticProvider{map[string]string{"p": src}}})
+        if err != nil {
+            return
+        }
+        var decoded interface{}
+        json.Unmarshal([]byte(jsonData), &decoded)
+        var typedNil *noPanicData
+        data := []interface{}{
+            decoded,
+            noPanicData{Iface: typedNil, Slice: []*int{nil}},
+            &noPanicData{Map: map[string]interface{}{"x": nil}, Embedded: &Embedded{"e"}},
+            typedNil,
+            nil,
+        }
+        for _, opts := range []RenderOptions{{NoPanic: true}, {NoPanic: true, MergeContexts: true, PointerMethods: true}} {
+            err := tmpl.FRenderOptions(ioutil.Discard, opts, data...)
+            if perr, ok := err.(*PanicError); ok {
+                t.Fatalf("%q with %s: %v\n%s", src, jsonData, perr, perr.Stack)
+            }
+        }
+    })
+}
+
+func BenchmarkParseString(b *testing.B) {
+    var buf bytes.Buffer
+    for i := 0; i < 1000; i++ {
+        buf.WriteString("<div style=\"{color: red}\">{ {{name}} }</div>\n{{#items}}<p>{{{html}}} {{! note }}</p>{{/items}}\n")
+    }
+    data := buf.String()
+    b.SetBytes(int64(len(data)))
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if _, err := ParseString(data); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func TestArena(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"item": "<li>{{name}}{{! note }}</li>\n"}}
+    data := "{{title}}\n{{#items}}\n  {{>item}}\n{{/items}}\n{{^items}}none{{/items}}{{{raw}}}{{&raw}}\n"
+    context := map[string]interface{}{
+        "title": "List",
+        "items": []map[string]string{{"name": "a"}, {"name": "b"}},
+        "raw":   "<b>",
+    }
+    for _, strict := range []bool{false, true} {
+        expected, err := ParseStringOptions(data, ParseOptions{Partials: partials, SpecStrict: strict})
+        if err != nil {
+            t.Fatal(err)
+        }
+        tmpl, err := ParseStringOptions(data, ParseOptions{Partials: partials, SpecStrict: strict, Arena: true})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got, want := tmpl.Render(context), expected.Render(context); got != want {
+            t.Fatalf("strict %v: expected %q got %q", strict, want, got)
+        }
+    }
+}
+
+func TestInternedNames(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"p": "{{ name }}"}}
+    tmpl, err := ParseStringPartials("{{name}}{{#name}}{{&name}}{{/name}}{{{name}}}{{>p}}", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, elem := range flatten(tmpl.elems, nil) {
+        if elem.kind != '/' && elem.kind != '>' {
+            names = append(names, elem.value)
+        }
+    }
+    partial := tmpl.elems[len(tmpl.elems)-1].(*partialElement).tmpl
+    names = append(names, partial.elems[0].(*varElement).name)
+    if len(names) != 5 {
+        t.Fatalf("expected 5 names, got %q", names)
+    }
+    for _, name := range names {
+        if name != "name" || unsafe.StringData(name) != unsafe.StringData(names[0]) {
+            t.Fatalf("expected %q to be stored once", names)
+        }
+    }
+}
+
+// BenchmarkParseArena parses many small templates, such as per-record
+// notification bodies, with and without an arena.
+func BenchmarkParseArena(b *testing.B) {
+    data := "Hi {{name}},\n{{#orders}}- {{id}}: {{total}}\n{{/orders}}{{^orders}}No orders.{{/orders}}\n{{! footer }}Thanks, {{shop}}"
+    for _, opts := range []ParseOptions{{}, {Arena: true}} {
+        b.Run(fmt.Sprintf("arena=%v", opts.Arena), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := ParseStringOptions(data, opts); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+func BenchmarkParseBraces(b *testing.B) {
+    data := strings.Repeat(".a{color:red} .b{margin:0} ", 10000) + "{{name}}"
+    b.SetBytes(int64(len(data)))
+    for i := 0; i < b.N; i++ {
+        if _, err := ParseString(data); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
 type LayoutTest struct {
     layout   string
     tmpl     string
@@ -261,3 +1625,35 @@ func TestLayout(t *testing.T) {
         }
     }
 }
+
+func TestLayoutPartials(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "nav":  "<nav>{{site}}</nav>",
+        "user": "{{name}}",
+    }}
+    output := RenderInLayoutPartials(`Hello {{>user}}`, `{{>nav}} {{{content}}}`, partials,
+        map[string]string{"site": "Example", "name": "World"})
+    if expected := "<nav>Example</nav> Hello World"; output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+    output = RenderInLayoutPartials(`Hello`, `{{>footer}}`, partials, nil)
+    if expected := `Could not find partial "footer"`; output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+}
+
+func TestFileLayoutPartials(t *testing.T) {
+    dir := t.TempDir()
+    ioutil.WriteFile(filepath.Join(dir, "layout.mustache"), []byte("{{>nav}}|{{{content}}}"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "page.mustache"), []byte("{{>greeting}}"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "nav.mustache"), []byte("file nav"), 0644)
+    partials := &StaticProvider{map[string]string{
+        "nav":      "<nav>{{site}}</nav>",
+        "greeting": "Hello {{name}}",
+    }}
+    output := RenderFileInLayoutPartials(filepath.Join(dir, "page.mustache"), filepath.Join(dir, "layout.mustache"),
+        partials, map[string]string{"site": "Example", "name": "World"})
+    if expected := "<nav>Example</nav>|Hello World"; output != expected {
+        t.Fatalf("expected %q got %q", expected, output)
+    }
+}
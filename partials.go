@@ -0,0 +1,174 @@
+package mustache
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// PartialProvider supplies the source of the partials referenced by a
+// template. Get returns an error if the named partial does not exist.
+type PartialProvider interface {
+    Get(name string) (string, error)
+}
+
+// ContextPartialProvider is a PartialProvider whose partials may differ
+// from one render to the next, for instance by tenant. Templates using one
+// resolve their partials when rendering rather than when parsing, calling
+// GetContext with the Context from RenderOptions.
+type ContextPartialProvider interface {
+    PartialProvider
+    GetContext(ctx context.Context, name string) (string, error)
+}
+
+// AssetProvider supplies the assets that asset tags insert, as set with
+// ParseOptions.Assets. Its method is that of PartialProvider, so that a
+// FileProvider or StaticProvider can serve assets too; a FileProvider
+// without Extensions finds a file by its full name, such as "logo.svg".
+type AssetProvider interface {
+    Get(name string) (string, error)
+}
+
+// PartialNotFoundError is returned by a PartialProvider that has no partial
+// of the given name.
+type PartialNotFoundError struct {
+    Name string
+}
+
+func (e *PartialNotFoundError) Error() string {
+    return fmt.Sprintf("Could not find partial %q", e.Name)
+}
+
+// FileProvider looks partials up on disk. A partial called NAME is searched
+// for in each of Paths, as NAME followed by each of Extensions in turn. The
+// empty path is the working directory. Without Extensions, the names NAME,
+// NAME.mustache and NAME.stache are tried. Paths use the operating system's
+// separators, while partial names always use slashes, as in {{>forms/input}}.
+//
+// Files are read with ReadFile, or ioutil.ReadFile if it is nil, so that
+// partials can come from elsewhere. To read from an fs.FS, call fs.ReadFile
+// with the name converted by filepath.ToSlash. ReadFile must report a
+// missing file with an error wrapping os.ErrNotExist, so that the remaining
+// paths are searched.
+type FileProvider struct {
+    Paths      []string
+    Extensions []string
+    ReadFile   func(filename string) ([]byte, error)
+}
+
+func (fp *FileProvider) Get(name string) (string, error) {
+    data, _, err := fp.find(name)
+    return data, err
+}
+
+// find returns the named partial and the file it was read from.
+func (fp *FileProvider) find(name string) (string, string, error) {
+    exts := fp.Extensions
+    if exts == nil {
+        exts = []string{"", ".mustache", ".stache"}
+    }
+    paths := fp.Paths
+    if paths == nil {
+        paths = []string{""}
+    }
+    readFile := fp.ReadFile
+    if readFile == nil {
+        readFile = ioutil.ReadFile
+    }
+    for _, p := range paths {
+        for _, ext := range exts {
+            filename := filepath.Join(p, filepath.FromSlash(name+ext))
+            data, err := readFile(filename)
+            if err == nil {
+                return string(data), filename, nil
+            }
+            if !errors.Is(err, os.ErrNotExist) {
+                return "", "", err
+            }
+        }
+    }
+    return "", "", &PartialNotFoundError{name}
+}
+
+// within returns a provider searching dir before the paths of fp, for the
+// partials of a partial read from dir.
+func (fp *FileProvider) within(dir string) *FileProvider {
+    paths := fp.Paths
+    if paths == nil {
+        paths = []string{""}
+    }
+    if filepath.Clean(paths[0]) == dir {
+        return fp
+    }
+    return &FileProvider{append([]string{dir}, paths...), fp.Extensions, fp.ReadFile}
+}
+
+// StaticProvider serves partials from a map of names to template sources.
+type StaticProvider struct {
+    Partials map[string]string
+}
+
+func (sp *StaticProvider) Get(name string) (string, error) {
+    if data, ok := sp.Partials[name]; ok {
+        return data, nil
+    }
+    return "", &PartialNotFoundError{name}
+}
+
+// LocaleProvider looks up locale-specific variants of partials in another
+// provider. For the locale "fr-CA", the partial "name" is looked up as
+// "name.fr-CA", then as "name.fr", and finally as "name", so that with a
+// FileProvider it is read from name.fr-CA.mustache, name.fr.mustache or
+// name.mustache. Locale subtags may be separated by "-" or "_".
+type LocaleProvider struct {
+    Partials PartialProvider
+    Locale   string
+}
+
+func (lp *LocaleProvider) Get(name string) (string, error) {
+    locale := lp.Locale
+    for locale != "" {
+        data, err := lp.Partials.Get(name + "." + locale)
+        if _, ok := err.(*PartialNotFoundError); !ok {
+            return data, err
+        }
+        i := strings.LastIndexAny(locale, "-_")
+        if i < 0 {
+            break
+        }
+        locale = locale[:i]
+    }
+    return lp.Partials.Get(name)
+}
+
+// ThemeProvider lets themes override individual partials. The providers in
+// Themes are searched in order before Base, so a theme only has to supply
+// the partials it changes.
+type ThemeProvider struct {
+    Themes []PartialProvider
+    Base   PartialProvider
+}
+
+// NewThemeProvider returns a ThemeProvider reading partials from the theme
+// directories, in order, and then from the base directory.
+func NewThemeProvider(base string, themes ...string) *ThemeProvider {
+    tp := &ThemeProvider{Base: &FileProvider{Paths: []string{base}}}
+    for _, dir := range themes {
+        tp.Themes = append(tp.Themes, &FileProvider{Paths: []string{dir}})
+    }
+    return tp
+}
+
+func (tp *ThemeProvider) Get(name string) (string, error) {
+    for _, theme := range tp.Themes {
+        data, err := theme.Get(name)
+        if _, ok := err.(*PartialNotFoundError); !ok {
+            return data, err
+        }
+    }
+    return tp.Base.Get(name)
+}
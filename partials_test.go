@@ -0,0 +1,558 @@
+package mustache
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io/fs"
+    "io/ioutil"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+func TestStaticProvider(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "user":   "{{name}}{{>suffix}}",
+        "suffix": "!",
+    }}
+    output := RenderPartials(`hello {{>user}}`, partials, map[string]string{"name": "world"})
+    if output != "hello world!" {
+        t.Fatalf("expected %q got %q", "hello world!", output)
+    }
+    output = RenderPartials(`{{>missing}}`, partials, nil)
+    if output != `Could not find partial "missing"` {
+        t.Fatalf("expected missing partial error, got %q", output)
+    }
+}
+
+func TestFileProvider(t *testing.T) {
+    dir := path.Join(os.Getenv("PWD"), "tests")
+    fp := &FileProvider{Paths: []string{"nowhere", dir}}
+    if data, err := fp.Get("partial"); err != nil || data != "{{Name}}" {
+        t.Fatalf("expected partial.mustache, got %q, %v", data, err)
+    }
+    fp = &FileProvider{Paths: []string{dir}, Extensions: []string{".stache"}}
+    if _, err := fp.Get("partial"); err == nil {
+        t.Fatal("expected an error for a partial with an unlisted extension")
+    }
+
+    // partial names use slashes whatever the platform's separator
+    dir = t.TempDir()
+    os.MkdirAll(filepath.Join(dir, "views", "forms"), 0755)
+    ioutil.WriteFile(filepath.Join(dir, "views", "forms", "input.mustache"), []byte("<input name={{name}}>"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "views", "page.mustache"), []byte("{{>forms/input}}"), 0644)
+    tmpl, err := ParseFile(filepath.Join(dir, "views", "page.mustache"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if output := tmpl.Render(map[string]string{"name": "q"}); output != "<input name=q>" {
+        t.Fatalf("unexpected output %q", output)
+    }
+}
+
+func TestNestedFilePartials(t *testing.T) {
+    dir := t.TempDir()
+    os.MkdirAll(filepath.Join(dir, "widgets"), 0755)
+    ioutil.WriteFile(filepath.Join(dir, "page.mustache"), []byte("page[{{>widgets/card}}]{{>icon}}"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "icon.mustache"), []byte("root"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "widgets", "card.mustache"), []byte("card({{>icon}}{{>footer}})"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "widgets", "icon.mustache"), []byte("ICON"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "footer.mustache"), []byte("."), 0644)
+
+    // a partial's partials are searched for next to it, then in the
+    // template's search path
+    for _, opts := range []ParseOptions{{}, {SpecStrict: true}} {
+        tmpl, err := ParseFileOptions(filepath.Join(dir, "page.mustache"), opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if output := tmpl.Render(nil); output != "page[card(ICON.)]root" {
+            t.Fatalf("unexpected output %q", output)
+        }
+        if err := tmpl.Check(nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+    tmpl, err := ParseStringPartials("{{>widgets/card}}", &FileProvider{Paths: []string{dir}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if output := tmpl.Render(nil); output != "card(ICON.)" {
+        t.Fatalf("unexpected output %q", output)
+    }
+    if err := tmpl.Check(&FileProvider{Paths: []string{dir}}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestFileProviderReadFile(t *testing.T) {
+    fsys := fstest.MapFS{
+        "views/header.mustache": {Data: []byte("<h1>{{title}}</h1>")},
+        "base/footer.stache":    {Data: []byte("<footer>")},
+    }
+    fp := &FileProvider{
+        Paths:    []string{"views", "base"},
+        ReadFile: func(name string) ([]byte, error) { return fs.ReadFile(fsys, filepath.ToSlash(name)) },
+    }
+    for name, expected := range map[string]string{"header": "<h1>{{title}}</h1>", "footer": "<footer>"} {
+        if data, err := fp.Get(name); err != nil || data != expected {
+            t.Fatalf("%s: expected %q got %q, %v", name, expected, data, err)
+        }
+    }
+    if _, err := fp.Get("missing"); err == nil || err.Error() != `Could not find partial "missing"` {
+        t.Fatalf("expected a missing partial error, got %v", err)
+    }
+
+    denied := errors.New("access denied")
+    fp.ReadFile = func(string) ([]byte, error) { return nil, denied }
+    if _, err := fp.Get("header"); err != denied {
+        t.Fatalf("expected the reader's error, got %v", err)
+    }
+}
+
+func TestLocaleProvider(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "greeting.fr-CA": "Allô",
+        "greeting.fr":    "Bonjour",
+        "greeting":       "Hello",
+        "bye.fr":         "Au revoir",
+        "thanks":         "Thanks",
+    }}
+    tests := []struct {
+        locale   string
+        name     string
+        expected string
+    }{
+        {"fr-CA", "greeting", "Allô"},
+        {"fr_CA", "greeting", "Bonjour"},
+        {"fr-FR", "greeting", "Bonjour"},
+        {"fr", "greeting", "Bonjour"},
+        {"en-US", "greeting", "Hello"},
+        {"", "greeting", "Hello"},
+        {"fr-CA", "bye", "Au revoir"},
+        {"fr-CA", "thanks", "Thanks"},
+    }
+    for _, test := range tests {
+        lp := &LocaleProvider{partials, test.locale}
+        data, err := lp.Get(test.name)
+        if err != nil || data != test.expected {
+            t.Fatalf("%s in %q: expected %q got %q, %v", test.name, test.locale, test.expected, data, err)
+        }
+    }
+    lp := &LocaleProvider{partials, "fr-CA"}
+    if _, err := lp.Get("missing"); err == nil || err.Error() != `Could not find partial "missing"` {
+        t.Fatalf("expected a missing partial error, got %v", err)
+    }
+    fp := &LocaleProvider{&FileProvider{Paths: []string{path.Join(os.Getenv("PWD"), "tests")}}, "fr"}
+    if data, err := fp.Get("partial"); err != nil || data != "{{Name}}" {
+        t.Fatalf("expected to fall back to partial.mustache, got %q, %v", data, err)
+    }
+}
+
+func TestThemeProvider(t *testing.T) {
+    base := &StaticProvider{map[string]string{"header": "base header", "footer": "base footer"}}
+    tenant := &StaticProvider{map[string]string{"header": "tenant header"}}
+    holiday := &StaticProvider{map[string]string{"footer": "holiday footer"}}
+    tp := &ThemeProvider{[]PartialProvider{holiday, tenant}, base}
+    output := RenderPartials(`{{>header}}, {{>footer}}`, tp, nil)
+    if output != "tenant header, holiday footer" {
+        t.Fatalf("expected themed partials, got %q", output)
+    }
+    if _, err := tp.Get("missing"); err == nil {
+        t.Fatal("expected an error for a missing partial")
+    }
+
+    dir := path.Join(os.Getenv("PWD"), "tests")
+    tp = NewThemeProvider(dir, path.Join(dir, "nowhere"))
+    if data, err := tp.Get("partial"); err != nil || data != "{{Name}}" {
+        t.Fatalf("expected the base partial, got %q, %v", data, err)
+    }
+}
+
+type tenantKey struct{}
+
+// tenantProvider serves partials for the tenant named in the context.
+type tenantProvider map[string]map[string]string
+
+func (tp tenantProvider) Get(name string) (string, error) {
+    return tp.GetContext(context.Background(), name)
+}
+
+func (tp tenantProvider) GetContext(ctx context.Context, name string) (string, error) {
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    tenant, _ := ctx.Value(tenantKey{}).(string)
+    if data, ok := tp[tenant][name]; ok {
+        return data, nil
+    }
+    return "", &PartialNotFoundError{name}
+}
+
+type tenantUser struct {
+    Name string
+}
+
+func (u tenantUser) Greeting(ctx context.Context) string {
+    tenant, _ := ctx.Value(tenantKey{}).(string)
+    return "hi from " + tenant + ", " + u.Name
+}
+
+func TestContextPartialProvider(t *testing.T) {
+    tp := tenantProvider{
+        "acme":   {"header": "ACME {{Greeting}}"},
+        "globex": {"header": "Globex {{Greeting}}"},
+    }
+    tmpl, err := ParseStringPartials(`{{>header}}!`, tp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, tenant := range []string{"acme", "globex"} {
+        var buf bytes.Buffer
+        ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+        err := tmpl.FRenderOptions(&buf, RenderOptions{Context: ctx}, tenantUser{"Bob"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        expected := map[string]string{"acme": "ACME hi from acme, Bob!", "globex": "Globex hi from globex, Bob!"}[tenant]
+        if buf.String() != expected {
+            t.Fatalf("expected %q got %q", expected, buf.String())
+        }
+    }
+
+    if output := tmpl.Render(tenantUser{"Bob"}); output != `Could not find partial "header"` {
+        t.Fatalf("expected a missing partial error, got %q", output)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    err = tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Context: ctx}, nil)
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("expected the context's error, got %v", err)
+    }
+
+    recursive := tenantProvider{"": {"self": "{{>self}}"}}
+    tmpl, _ = ParseStringOptions(`{{>self}}`, ParseOptions{Partials: recursive, MaxDepth: 10})
+    var lerr *LimitError
+    if !errors.As(tmpl.FRender(ioutil.Discard), &lerr) {
+        t.Fatal("expected a limit error for a recursive partial")
+    }
+}
+
+func TestRenderOptionsPartials(t *testing.T) {
+    defaults := &StaticProvider{map[string]string{"header": "Default", "footer": "<footer>"}}
+    tenants := map[string]PartialProvider{
+        "acme":   &StaticProvider{map[string]string{"header": "ACME {{>logo}}", "logo": "{{name}}", "footer": "</acme>"}},
+        "globex": &StaticProvider{map[string]string{"header": "Globex", "footer": ""}},
+    }
+    tests := []struct {
+        src      string
+        tenant   string
+        expected string
+    }{
+        {`{{>header}}|{{>footer}}`, "", "Default|<footer>"},
+        {`{{>header}}|{{>footer}}`, "acme", "ACME Bob|</acme>"},
+        {`{{>header}}|{{>footer}}`, "globex", "Globex|"},
+        {`{{#user}}{{>header}}{{/user}}`, "acme", "ACME Bob"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.src, defaults)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var buf bytes.Buffer
+        err = tmpl.FRenderOptions(&buf, RenderOptions{Partials: tenants[test.tenant]},
+            map[string]interface{}{"name": "Bob", "user": true})
+        if err != nil || buf.String() != test.expected {
+            t.Fatalf("%q for %q: expected %q got %q, %v", test.src, test.tenant, test.expected, buf.String(), err)
+        }
+    }
+
+    tmpl, _ := ParseStringPartials(`{{>header}}`, defaults)
+    err := tmpl.FRenderOptions(ioutil.Discard, RenderOptions{Partials: &StaticProvider{map[string]string{}}})
+    if err == nil || err.Error() != `Could not find partial "header"` {
+        t.Fatalf("expected a missing partial error, got %v", err)
+    }
+}
+
+func TestPartialOverrides(t *testing.T) {
+    defaults := &StaticProvider{map[string]string{"header": "Default", "footer": "<footer>", "page": "[{{>header}}]"}}
+    acme := &StaticProvider{map[string]string{"header": "ACME {{name}}", "footer": "</acme>"}}
+    tests := []struct {
+        src       string
+        partials  PartialProvider
+        overrides map[string]string
+        expected  string
+    }{
+        {`{{>header}}|{{>footer}}`, nil, nil, "Default|<footer>"},
+        {`{{>header}}|{{>footer}}`, nil, map[string]string{"header": "New {{name}}"}, "New Bob|<footer>"},
+        {`{{>page}}`, nil, map[string]string{"header": "New"}, "[New]"},
+        {`{{>header}}|{{>footer}}`, acme, map[string]string{"footer": "</new>"}, "ACME Bob|</new>"},
+        {`{{>header}}`, nil, map[string]string{"header": "{{>footer}}!"}, "<footer>!"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.src, defaults)
+        if err != nil {
+            t.Fatal(err)
+        }
+        var buf bytes.Buffer
+        err = tmpl.FRenderOptions(&buf, RenderOptions{Partials: test.partials, PartialOverrides: test.overrides},
+            map[string]string{"name": "Bob"})
+        if err != nil || buf.String() != test.expected {
+            t.Fatalf("%q with %v: expected %q got %q, %v", test.src, test.overrides, test.expected, buf.String(), err)
+        }
+    }
+
+    tmpl, _ := ParseStringPartials(`{{>header}}`, defaults)
+    err := tmpl.FRenderOptions(ioutil.Discard, RenderOptions{PartialOverrides: map[string]string{"header": "{{#a}}"}})
+    expected := `line 1: Section a has no closing tag, in partial "header" included from the template on line 1`
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+}
+
+func TestAssets(t *testing.T) {
+    assets := &StaticProvider{map[string]string{"cart.svg": "<svg>{{cart}}</svg>", "a.css": "a > b {}"}}
+    tests := []struct {
+        tmpl     string
+        opts     ParseOptions
+        expected string
+    }{
+        {`<i>{{@cart.svg}}</i>{{@ a.css }}`, ParseOptions{Assets: assets}, "<i><svg>{{cart}}</svg></i>a > b {}"},
+        {`{{@cart.svg}}`, ParseOptions{Assets: assets, EscapeAsset: func(name, data string) string { return name + ":" + EscapeHTML(data) }},
+            "cart.svg:&lt;svg&gt;{{cart}}&lt;/svg&gt;"},
+        {`{{#x}}{{@a.css}}{{/x}}`, ParseOptions{Assets: assets}, "a > b {}"},
+        {`{{>p}}`, ParseOptions{Assets: assets, Partials: &StaticProvider{map[string]string{"p": "[{{@a.css}}]"}}}, "[a > b {}]"},
+        {`{{@partial.mustache}}`, ParseOptions{Assets: &FileProvider{Paths: []string{path.Join(os.Getenv("PWD"), "tests")}}}, "{{Name}}"},
+        {`{{@cart}}`, ParseOptions{}, "variable"},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringOptions(test.tmpl, test.opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        output := tmpl.Render(map[string]interface{}{"x": true, "@cart": "variable"})
+        if output != test.expected {
+            t.Errorf("%s: expected %q got %q", test.tmpl, test.expected, output)
+        }
+    }
+
+    _, err := ParseStringOptions("\n{{@missing.svg}}", ParseOptions{Assets: assets})
+    if err == nil || err.Error() != `line 2: asset missing.svg: Could not find partial "missing.svg"` {
+        t.Fatalf("expected a missing asset error, got %v", err)
+    }
+}
+
+func TestCheck(t *testing.T) {
+    tp := tenantProvider{"": {
+        "page":   "{{>header}}{{#items}}{{>item}}{{/items}}",
+        "header": "<h1>{{title}}</h1>",
+        "item":   "{{>price}}",
+        "price":  "{{#amount}}",
+    }}
+    tmpl, err := ParseStringPartials(`{{>page}}`, tp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = tmpl.Check(nil)
+    expected := `line 1: Section amount has no closing tag, in partial "price" included from partial "item" on line 1,` +
+        ` included from partial "page" on line 1, included from the template on line 1`
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+
+    fixed := &StaticProvider{map[string]string{
+        "page":   "{{>header}}{{#items}}{{>item}}{{/items}}",
+        "header": "<h1>{{title}}</h1>",
+        "item":   "{{>price}}",
+        "price":  "{{amount}}",
+    }}
+    if err := tmpl.Check(fixed); err != nil {
+        t.Fatal(err)
+    }
+    delete(fixed.Partials, "header")
+    expected = `Could not find partial "header", in partial "page" included from the template on line 1`
+    if err := tmpl.Check(fixed); err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+}
+
+func TestFlatten(t *testing.T) {
+    partials := map[string]string{
+        "page":   "{{>header}}\n{{#items}}\n  {{>item}}\n{{/items}}\n",
+        "header": "<h1>{{title}}</h1>",
+        "item":   "<li>{{name}}</li>\n{{=<% %>=}}<%>price%>\n",
+        "price":  "{{#price}}${{.}}{{/price}}\n",
+        "tree":   "{{name}}{{#kids}}({{>tree}}){{/kids}}",
+    }
+    context := map[string]interface{}{
+        "title": "Shop",
+        "items": []map[string]interface{}{{"name": "a", "price": 1}, {"name": "b"}},
+        "name":  "root",
+        "kids":  []map[string]interface{}{{"name": "leaf"}},
+    }
+    tests := []struct {
+        tmpl     string
+        opts     ParseOptions
+        provider PartialProvider
+        partials int // partial tags left in the flattened template
+    }{
+        {`{{>page}}`, ParseOptions{Partials: &StaticProvider{partials}}, nil, 0},
+        {`{{>page}}`, ParseOptions{Partials: &StaticProvider{partials}, SpecStrict: true}, nil, 0},
+        {`{{>page}}`, ParseOptions{Partials: tenantProvider{"": partials}, SpecStrict: true}, nil, 1},
+        {`{{>page}}`, ParseOptions{Partials: tenantProvider{"": partials}, SpecStrict: true}, &StaticProvider{partials}, 0},
+        {`{{>tree}}`, ParseOptions{Partials: &StaticProvider{partials}, SpecStrict: true}, nil, 1},
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringOptions(test.tmpl, test.opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        flat, err := tmpl.Flatten(test.provider)
+        if err != nil {
+            t.Fatal(err)
+        }
+        count := 0
+        for _, node := range flatten(flat.elems, nil) {
+            if node.kind == '>' {
+                count++
+            }
+        }
+        if count != test.partials {
+            t.Errorf("%s: expected %d partial tags, got %d in %q", test.tmpl, test.partials, count, flat.Unparse())
+        }
+        expected := tmpl.Render(context)
+        if output := flat.Render(context); output != expected {
+            t.Errorf("%s: expected %q got %q", test.tmpl, expected, output)
+        }
+        if count == 0 {
+            reparsed, err := ParseStringOptions(flat.Unparse(), ParseOptions{SpecStrict: test.opts.SpecStrict})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if output := reparsed.Render(context); output != expected {
+                t.Errorf("%s: expected %q from the unparsed template, got %q", test.tmpl, expected, output)
+            }
+        }
+        if again := tmpl.Render(context); again != expected {
+            t.Errorf("%s: flattening changed the template, rendering %q", test.tmpl, again)
+        }
+    }
+
+    tmpl, _ := ParseStringOptions(`{{>page}}`, ParseOptions{Partials: tenantProvider{"": partials}})
+    _, err := tmpl.Flatten(&StaticProvider{map[string]string{"page": "{{>header}}"}})
+    expected := `Could not find partial "header", in partial "page" included from the template on line 1`
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+}
+
+func TestPartialDelimiters(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "erb":   "{{=<% %>=}}<%a%>{{a}}",
+        "plain": "{{a}}<%a%>",
+    }}
+    tp := tenantProvider{"": partials.Partials}
+    tests := []struct {
+        tmpl     string
+        expected string
+    }{
+        {`{{>erb}}|{{a}}|{{>plain}}`, "1{{a}}|1|1<%a%>"},
+        {`{{=<% %>=}}<%>plain%>|<%a%>{{a}}`, "1<%a%>|1{{a}}"},
+        {`{{#s}}{{>erb}}{{/s}}{{a}}`, "1{{a}}1"},
+    }
+    for _, test := range tests {
+        for _, provider := range []PartialProvider{partials, tp} {
+            layout, err := ParseStringPartials(`{{=[ ]=}}[&content]`, provider)
+            if err != nil {
+                t.Fatal(err)
+            }
+            tmpl, err := ParseStringPartials(test.tmpl, provider)
+            if err != nil {
+                t.Fatal(err)
+            }
+            context := map[string]interface{}{"a": 1, "s": true}
+            if output := tmpl.RenderInLayout(layout, context); output != test.expected {
+                t.Fatalf("%q with %T: expected %q got %q", test.tmpl, provider, test.expected, output)
+            }
+        }
+    }
+}
+
+func TestRewritePartialName(t *testing.T) {
+    partials := &StaticProvider{map[string]string{
+        "header.html":     "<h1>{{>legacy/title}}</h1>",
+        "page-title.html": "{{title}}",
+    }}
+    renames := map[string]string{"legacy/title": "page-title"}
+    rewrite := func(name string) string {
+        if renamed, ok := renames[name]; ok {
+            name = renamed
+        }
+        return strings.ToLower(name) + ".html"
+    }
+    tmpl, err := ParseStringOptions(`{{>Header}}`, ParseOptions{Partials: partials, RewritePartialName: rewrite})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if output := tmpl.Render(map[string]string{"title": "Hi"}); output != "<h1>Hi</h1>" {
+        t.Fatalf("expected %q got %q", "<h1>Hi</h1>", output)
+    }
+
+    tp := tenantProvider{"acme": {"header.html": "ACME"}}
+    tmpl, _ = ParseStringOptions(`{{>header}}`, ParseOptions{Partials: tp, RewritePartialName: rewrite})
+    var buf bytes.Buffer
+    ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+    if err := tmpl.FRenderOptions(&buf, RenderOptions{Context: ctx}, nil); err != nil || buf.String() != "ACME" {
+        t.Fatalf("expected %q got %q, %v", "ACME", buf.String(), err)
+    }
+}
+
+func TestPartialErrors(t *testing.T) {
+    static := map[string]string{
+        "layout": "<body>\n{{>nav}}\n{{>footer}}</body>",
+        "nav":    "nav",
+        "footer": "\n{{#links}}{{/link}}",
+    }
+    expected := `line 2: interleaved closing tag: link, in partial "footer" included from partial "layout" on line 3, included from the template on line 2`
+    _, err := ParseStringPartials("<html>\n{{>layout}}", &StaticProvider{static})
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+    if pe, ok := err.(*PartialError); !ok || pe.Name != "layout" || pe.Line != 2 {
+        t.Fatalf("expected a PartialError for layout, got %#v", err)
+    }
+
+    // partials resolved at render time report the same chain
+    tp := tenantProvider{"": static}
+    tmpl, err := ParseStringPartials("<html>\n{{>layout}}", tp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = tmpl.FRender(ioutil.Discard); err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+
+    // a missing partial is an error in the template that includes it
+    delete(static, "nav")
+    _, err = ParseStringPartials("{{>layout}}", &StaticProvider{static})
+    expected = `Could not find partial "nav", in partial "layout" included from the template on line 1`
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+    var notFound *PartialNotFoundError
+    if !errors.As(err, &notFound) || notFound.Name != "nav" {
+        t.Fatalf("expected to unwrap a PartialNotFoundError, got %#v", err)
+    }
+
+    // repeated inclusions of a recursive partial are collapsed
+    tmpl, err = ParseStringOptions("{{>self}}", ParseOptions{Partials: &StaticProvider{map[string]string{"self": "{{>self}}"}}, MaxDepth: 5})
+    expected = `line 1: nesting depth exceeds the limit of 5, in partial "self" included from partial "self" on line 1 (4 times), included from the template on line 1`
+    if err == nil || err.Error() != expected {
+        t.Fatalf("expected %q got %v", expected, err)
+    }
+}
@@ -0,0 +1,34 @@
+package mustache
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestWindowsPaths(t *testing.T) {
+    dir := t.TempDir()
+    os.MkdirAll(filepath.Join(dir, "views", "forms"), 0755)
+    ioutil.WriteFile(filepath.Join(dir, "views", "forms", "input.mustache"), []byte("<input>"), 0644)
+    ioutil.WriteFile(filepath.Join(dir, "views", "page.mustache"), []byte("{{>forms/input}}"), 0644)
+
+    // backslash separators and a drive letter
+    filename := strings.Replace(filepath.Join(dir, "views", "page.mustache"), "/", `\`, -1)
+    if filepath.VolumeName(filename) == "" {
+        t.Fatalf("expected %q to have a drive letter", filename)
+    }
+    tmpl, err := ParseFile(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if output := tmpl.Render(); output != "<input>" {
+        t.Fatalf("unexpected output %q", output)
+    }
+
+    fp := &FileProvider{Paths: []string{filepath.Join(dir, "views") + `\`}}
+    if data, err := fp.Get("forms/input"); err != nil || data != "<input>" {
+        t.Fatalf("expected forms/input, got %q, %v", data, err)
+    }
+}
@@ -0,0 +1,56 @@
+package mustache
+
+import (
+    "bytes"
+    "io"
+    "reflect"
+    "sync"
+)
+
+// maxPooledBuffer is the largest output buffer a RenderPool keeps for reuse,
+// so that one huge render does not pin its memory for good.
+const maxPooledBuffer = 1 << 20
+
+// RenderPool renders templates with output buffers and context chains that
+// are reused from one call to the next, for services rendering the same
+// templates at high rates. The zero value is ready to use, and a RenderPool
+// may be used from several goroutines at once.
+type RenderPool struct {
+    pool sync.Pool
+}
+
+type renderState struct {
+    buf   bytes.Buffer
+    chain []reflect.Value
+}
+
+// Render renders tmpl with the given context and writes the output to w in
+// a single call to Write. Nothing is written if rendering fails.
+func (p *RenderPool) Render(tmpl *Template, w io.Writer, context ...interface{}) error {
+    st, _ := p.pool.Get().(*renderState)
+    if st == nil {
+        st = new(renderState)
+    }
+
+    // leave room for the contexts pushed by sections
+    if n := len(context) + tmpl.nesting; cap(st.chain) < n {
+        st.chain = make([]reflect.Value, 0, n)
+    }
+    st.chain = appendContexts(st.chain[:0], context)
+    err := tmpl.renderTemplate(st.chain, &st.buf)
+    if err == nil {
+        _, err = w.Write(st.buf.Bytes())
+    }
+
+    // sections push onto the chain beyond its length, so clear all of it to
+    // let the data be collected
+    chain := st.chain[:cap(st.chain)]
+    for i := range chain {
+        chain[i] = reflect.Value{}
+    }
+    if st.buf.Cap() <= maxPooledBuffer {
+        st.buf.Reset()
+        p.pool.Put(st)
+    }
+    return err
+}
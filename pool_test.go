@@ -0,0 +1,65 @@
+package mustache
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "sync"
+    "testing"
+)
+
+func TestRenderPool(t *testing.T) {
+    var pool RenderPool
+    var wg sync.WaitGroup
+    for _, test := range tests {
+        tmpl, err := ParseString(test.tmpl)
+        if err != nil {
+            continue
+        }
+        test := test
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 10; i++ {
+                var buf bytes.Buffer
+                if err := pool.Render(tmpl, &buf, test.context); err != nil {
+                    t.Error(err)
+                }
+                if buf.String() != test.expected {
+                    t.Errorf("%q expected %q got %q", test.tmpl, test.expected, buf.String())
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestRenderPoolWriteError(t *testing.T) {
+    var pool RenderPool
+    tmpl, _ := ParseString("hello")
+    if err := pool.Render(tmpl, failingWriter{}); err == nil || err.Error() != "write failed" {
+        t.Fatalf("expected the write error, got %v", err)
+    }
+}
+
+func BenchmarkRender(b *testing.B) {
+    tmpl, _ := ParseString(`{{#users}}<li>{{Name}} {{#Truefunc1}}ok{{/Truefunc1}}</li>{{/users}}`)
+    data := map[string]interface{}{"users": []User{{"Mike", 1}, {"Joe", 2}}}
+    b.Run("Template", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            ioutil.Discard.Write([]byte(tmpl.Render(data)))
+        }
+    })
+    b.Run("RenderPool", func(b *testing.B) {
+        var pool RenderPool
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            pool.Render(tmpl, ioutil.Discard, data)
+        }
+    })
+}
@@ -0,0 +1,274 @@
+package mustache
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Registry is a set of named templates that may include each other as
+// partials. Templates are compiled on first use and cached. Because a
+// compiled template embeds its partials, the registry records which
+// partials each template includes, directly or not, and drops the compiled
+// form of every dependent when a partial is added, replaced or removed.
+// A Registry may be used from several goroutines at once.
+type Registry struct {
+    // Options are used to compile the registry's templates. Their
+    // Partials field is ignored, since partials come from the registry.
+    Options ParseOptions
+    // Metrics, if not nil, is told of each lookup of a compiled template
+    // and set on the templates the registry compiles.
+    Metrics Metrics
+    // ExposeVersion makes the variable VersionVariable render the version
+    // of the template being rendered, as reported by Version.
+    ExposeVersion bool
+
+    mu       sync.RWMutex
+    gen      int
+    sources  map[string]string
+    versions map[string]string
+    variants map[string][]string // the variants of each template, sorted
+    compiled map[string]*Template
+    deps     map[string]map[string]bool
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+    return &Registry{
+        sources:  map[string]string{},
+        versions: map[string]string{},
+        variants: map[string][]string{},
+        compiled: map[string]*Template{},
+        deps:     map[string]map[string]bool{},
+    }
+}
+
+// Add adds or replaces the template called name.
+func (r *Registry) Add(name, src string) {
+    r.AddVersion(name, src, "")
+}
+
+// AddVersion adds or replaces the template called name, stamping it with
+// version, such as a release number or a revision of the file it comes
+// from. An empty version has the registry compute one, as with Add.
+func (r *Registry) AddVersion(name, src, version string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.add(name, src, version)
+}
+
+// add adds or replaces the template called name. r.mu must be held for
+// writing.
+func (r *Registry) add(name, src, version string) {
+    r.sources[name] = src
+    if version != "" {
+        r.versions[name] = version
+    } else {
+        delete(r.versions, name)
+    }
+    r.invalidate(name)
+}
+
+// AddFile adds or replaces the template called name with the contents of
+// filename.
+func (r *Registry) AddFile(name, filename string) error {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return err
+    }
+    r.Add(name, string(data))
+    return nil
+}
+
+// Remove removes the template called name.
+func (r *Registry) Remove(name string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    delete(r.sources, name)
+    delete(r.versions, name)
+    r.invalidate(name)
+    for _, variant := range r.variants[name] {
+        key := variantKey(name, variant)
+        delete(r.sources, key)
+        delete(r.versions, key)
+        r.invalidate(key)
+    }
+    delete(r.variants, name)
+}
+
+// invalidate drops the compiled form of name and of every template that
+// includes it. r.mu must be held for writing.
+func (r *Registry) invalidate(name string) {
+    r.gen++
+    delete(r.compiled, name)
+    delete(r.deps, name)
+    for dependent, deps := range r.deps {
+        if deps[name] {
+            delete(r.compiled, dependent)
+            delete(r.deps, dependent)
+        }
+    }
+}
+
+// Names returns the names of the registered templates, sorted.
+func (r *Registry) Names() []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    names := make([]string, 0, len(r.sources))
+    for name := range r.sources {
+        if !strings.Contains(name, variantSep) {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Get returns the source of the template called name, so that a Registry
+// can be used as a PartialProvider.
+func (r *Registry) Get(name string) (string, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    src, ok := r.sources[name]
+    if !ok {
+        return "", &PartialNotFoundError{name}
+    }
+    return src, nil
+}
+
+// Dependencies returns the names of the partials the template called name
+// includes, directly or through other partials, sorted.
+func (r *Registry) Dependencies(name string) ([]string, error) {
+    if _, err := r.Template(name); err != nil {
+        return nil, err
+    }
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    var names []string
+    for dep := range r.deps[name] {
+        names = append(names, dep)
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// VersionVariable is the variable that renders the version of a template
+// rendered by a Registry with ExposeVersion set.
+const VersionVariable = "__template_version"
+
+// Version returns the version of the template called name, which changes
+// whenever what it renders may, so that artifacts made from it can be
+// cached by version. It is the version given to AddVersion, if any, or else
+// a hash of the template's source and of the versions of the partials it
+// includes, directly or not.
+func (r *Registry) Version(name string) (string, error) {
+    deps, err := r.Dependencies(name)
+    if err != nil {
+        return "", err
+    }
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    if version, ok := r.versions[name]; ok {
+        return version, nil
+    }
+    h := sha256.New()
+    io.WriteString(h, r.sources[name])
+    for _, dep := range deps {
+        fmt.Fprintf(h, "\x00%s\x00%s", dep, r.ownVersion(dep))
+    }
+    return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// ownVersion returns the version given for the template called name, or a
+// hash of its source, ignoring its partials. r.mu must be held.
+func (r *Registry) ownVersion(name string) string {
+    if version, ok := r.versions[name]; ok {
+        return version
+    }
+    src, ok := r.sources[name]
+    if !ok {
+        return ""
+    }
+    sum := sha256.Sum256([]byte(src))
+    return hex.EncodeToString(sum[:8])
+}
+
+// recordingProvider serves partials from a registry and records the names
+// asked for.
+type recordingProvider struct {
+    r    *Registry
+    used map[string]bool
+}
+
+func (rp *recordingProvider) Get(name string) (string, error) {
+    rp.used[name] = true
+    return rp.r.Get(name)
+}
+
+// Template returns the compiled template called name.
+func (r *Registry) Template(name string) (*Template, error) {
+    r.mu.RLock()
+    tmpl, ok := r.compiled[name]
+    src, found := r.sources[name]
+    gen := r.gen
+    r.mu.RUnlock()
+    if r.Metrics != nil && found {
+        r.Metrics.CacheLookup(ok)
+    }
+    if ok {
+        return tmpl, nil
+    }
+    if !found {
+        return nil, fmt.Errorf("Could not find template %q", name)
+    }
+
+    rp := &recordingProvider{r, map[string]bool{}}
+    opts := r.Options
+    opts.Partials = rp
+    tmpl, err := ParseStringOptions(src, opts)
+    if err != nil {
+        return nil, err
+    }
+    if r.Metrics != nil {
+        tmpl.SetMetrics(r.Metrics)
+    }
+
+    r.mu.Lock()
+    // keep the result only if nothing changed while parsing
+    if r.gen == gen {
+        r.compiled[name] = tmpl
+        r.deps[name] = rp.used
+    }
+    r.mu.Unlock()
+    return tmpl, nil
+}
+
+// Render renders the template called name with the given context. If
+// rendering fails, the output is empty and the error is returned.
+func (r *Registry) Render(name string, context ...interface{}) (string, error) {
+    return r.render(name, context)
+}
+
+func (r *Registry) render(name string, context []interface{}) (string, error) {
+    tmpl, err := r.Template(name)
+    if err != nil {
+        return "", err
+    }
+    if r.ExposeVersion {
+        version, err := r.Version(name)
+        if err != nil {
+            return "", err
+        }
+        context = append(context[:len(context):len(context)], map[string]string{VersionVariable: version})
+    }
+    var sb strings.Builder
+    if err := tmpl.RenderTo(&sb, context...); err != nil {
+        return "", err
+    }
+    return sb.String(), nil
+}
@@ -0,0 +1,132 @@
+package mustache
+
+import (
+    "errors"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestRegistry(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "{{>layout}}")
+    r.Add("layout", "<h1>{{>title}}</h1>")
+    r.Add("title", "{{name}}")
+    r.Add("other", "other")
+
+    output, err := r.Render("page", map[string]string{"name": "Home"})
+    if err != nil || output != "<h1>Home</h1>" {
+        t.Fatalf("expected %q got %q, %v", "<h1>Home</h1>", output, err)
+    }
+    deps, _ := r.Dependencies("page")
+    if strings.Join(deps, ",") != "layout,title" {
+        t.Fatalf("expected page to depend on layout and title, got %v", deps)
+    }
+    other, _ := r.Template("other")
+
+    // replacing a partial recompiles everything that includes it, even
+    // indirectly, and nothing else
+    r.Add("title", "[{{name}}]")
+    output, _ = r.Render("page", map[string]string{"name": "Home"})
+    if output != "<h1>[Home]</h1>" {
+        t.Fatalf("expected %q got %q", "<h1>[Home]</h1>", output)
+    }
+    if tmpl, _ := r.Template("other"); tmpl != other {
+        t.Fatal("expected an unrelated template to stay compiled")
+    }
+
+    r.Remove("layout")
+    if _, err := r.Render("page", nil); err == nil || err.Error() != `Could not find partial "layout"` {
+        t.Fatalf("expected a missing partial error, got %v", err)
+    }
+    r.Add("layout", "{{>title}}!")
+    if output, _ = r.Render("page", map[string]string{"name": "x"}); output != "[x]!" {
+        t.Fatalf("expected %q got %q", "[x]!", output)
+    }
+    if _, err := r.Template("missing"); err == nil {
+        t.Fatal("expected an error for a missing template")
+    }
+    if names := r.Names(); strings.Join(names, ",") != "layout,other,page,title" {
+        t.Fatalf("unexpected names %v", names)
+    }
+}
+
+func TestRegistryRenderError(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "<p>{{fail id}}</p>")
+    r.AddVariant("page", "b", "<div>{{fail id}}</div>")
+    data := map[string]interface{}{"id": 1, "fail": func(int) (string, error) { return "", errors.New("boom") }}
+
+    output, err := r.Render("page", data)
+    if err == nil || err.Error() != "line 1: calling fail: boom" || output != "" {
+        t.Fatalf("expected the render's error and no output, got %q, %v", output, err)
+    }
+    output, variant, err := r.RenderVariant("page", func(string, []string) string { return "b" }, data)
+    if err == nil || variant != "b" || output != "" {
+        t.Fatalf("expected the variant's error and no output, got %q, %v", output, err)
+    }
+}
+
+func TestRegistryVersion(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "{{>header}}v{{__template_version}}")
+    r.Add("header", "<h1>")
+    r.Add("other", "other")
+
+    page, err := r.Version("page")
+    if err != nil || len(page) != 16 {
+        t.Fatalf("expected a hash, got %q, %v", page, err)
+    }
+    other, _ := r.Version("other")
+    if again, _ := r.Version("page"); again != page {
+        t.Fatalf("expected the version to stay %q, got %q", page, again)
+    }
+    r.Add("header", "<h2>")
+    if changed, _ := r.Version("page"); changed == page {
+        t.Fatal("expected a change to a partial to change the version")
+    }
+    if unchanged, _ := r.Version("other"); unchanged != other {
+        t.Fatal("expected an unrelated template to keep its version")
+    }
+
+    r.AddVersion("header", "<h3>", "v7")
+    if version, _ := r.Version("header"); version != "v7" {
+        t.Fatalf("expected the given version, got %q", version)
+    }
+    r.AddVersion("page", "{{>header}} {{__template_version}}", "v2")
+    if output, _ := r.Render("page"); output != "<h3> " {
+        t.Fatalf("expected the version not to render, got %q", output)
+    }
+    r.ExposeVersion = true
+    if output, _ := r.Render("page", map[string]string{}); output != "<h3> v2" {
+        t.Fatalf("expected the version to render, got %q", output)
+    }
+    r.Add("page", "{{__template_version}}")
+    if version, _ := r.Version("page"); version == "v2" {
+        t.Fatal("expected Add to drop the given version")
+    }
+    if _, err := r.Version("missing"); err == nil {
+        t.Fatal("expected an error for a missing template")
+    }
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "{{>part}}")
+    r.Add("part", "a")
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                r.Render("page", nil)
+                r.Add("part", "b")
+            }
+        }()
+    }
+    wg.Wait()
+    if output, _ := r.Render("page", nil); output != "b" {
+        t.Fatalf("expected %q got %q", "b", output)
+    }
+}
@@ -0,0 +1,62 @@
+package mustache
+
+// Segment is a part of a template: either literal text, which renders the
+// same whatever the data, or a dynamic part, which does not.
+type Segment struct {
+    // Literal is the text of a literal segment.
+    Literal []byte
+    // Template renders a dynamic segment, and is nil for a literal one.
+    Template *Template
+}
+
+// Segments splits the template into literal and dynamic segments, in
+// order, which rendered one after the other render as the template does.
+// Literal segments include the text of static partials, and never follow
+// one another. They let a server compress the literal text once, ahead of
+// time, and compress only the dynamic segments as it renders them.
+// Comments are dropped, so render options acting on them have no effect
+// on the segments.
+func (tmpl *Template) Segments() []Segment {
+    var segments []Segment
+    var dynamic []interface{}
+    endDynamic := func() {
+        if len(dynamic) > 0 {
+            part := *tmpl
+            part.elems = dynamic
+            part.static = nil
+            part.included = nil
+            segments = append(segments, Segment{Template: &part})
+            dynamic = nil
+        }
+    }
+    for _, elem := range tmpl.elems {
+        var literal []byte
+        switch elem := elem.(type) {
+        case *commentElement:
+            continue
+        case *textElement:
+            literal = elem.text
+        case *partialElement:
+            if elem.tmpl == nil || elem.tmpl.static == nil {
+                dynamic = append(dynamic, elem)
+                continue
+            }
+            literal = elem.tmpl.static
+        default:
+            dynamic = append(dynamic, elem)
+            continue
+        }
+        if len(literal) == 0 {
+            continue
+        }
+        endDynamic()
+        if n := len(segments); n > 0 && segments[n-1].Template == nil {
+            last := &segments[n-1]
+            last.Literal = append(last.Literal[:len(last.Literal):len(last.Literal)], literal...)
+        } else {
+            segments = append(segments, Segment{Literal: literal})
+        }
+    }
+    endDynamic()
+    return segments
+}
@@ -0,0 +1,42 @@
+package mustache
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestSegments(t *testing.T) {
+    partials := &StaticProvider{map[string]string{"head": "<head></head>", "user": "<b>{{name}}</b>"}}
+    tmpl, err := ParseStringPartials("<html>{{! page }}{{>head}}<body>{{name}}{{#items}}<i>{{.}}</i>{{/items}}{{>user}}</body>", partials)
+    if err != nil {
+        t.Fatal(err)
+    }
+    segments := tmpl.Segments()
+    var kinds []string
+    for _, s := range segments {
+        if s.Template == nil {
+            kinds = append(kinds, string(s.Literal))
+        } else {
+            kinds = append(kinds, "*")
+        }
+    }
+    if got := strings.Join(kinds, "|"); got != "<html><head></head><body>|*|</body>" {
+        t.Fatalf("unexpected segments %s", got)
+    }
+
+    data := map[string]interface{}{"name": "Bob", "items": []int{1, 2}}
+    var sb strings.Builder
+    for _, s := range segments {
+        if s.Template == nil {
+            sb.Write(s.Literal)
+        } else if err := s.Template.RenderTo(&sb, data); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if expected := tmpl.Render(data); sb.String() != expected {
+        t.Fatalf("expected %q got %q", expected, sb.String())
+    }
+    if output := tmpl.Render(data); output != sb.String() {
+        t.Fatal("expected Segments to leave the template unchanged")
+    }
+}
@@ -0,0 +1,141 @@
+package mustache
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "strings"
+)
+
+// SpecTest is one case of a suite in the format of the official mustache
+// spec's JSON files.
+type SpecTest struct {
+    Name     string
+    Desc     string
+    Data     interface{}
+    Template string
+    Partials map[string]string
+    Expected string
+}
+
+// SpecOptions configures RunSpec.
+type SpecOptions struct {
+    // Options are used to parse each test's template, except that partials
+    // always come from the test.
+    Options ParseOptions
+    // Skip, if not nil, reports tests not to run, such as those exercising
+    // features the engine does not support.
+    Skip func(test SpecTest) bool
+}
+
+// SpecResult is the outcome of a SpecTest. Err is set if the template
+// failed to parse or render.
+type SpecResult struct {
+    Name     string
+    Skipped  bool
+    Passed   bool
+    Expected string
+    Output   string
+    Err      error
+}
+
+// RunSpec runs the tests of a spec suite read from r, returning a result
+// for each in order. An error is returned only if the suite cannot be read.
+func RunSpec(r io.Reader, opts SpecOptions) ([]SpecResult, error) {
+    var suite struct {
+        Tests []SpecTest `json:"tests"`
+    }
+    if err := json.NewDecoder(r).Decode(&suite); err != nil {
+        return nil, err
+    }
+
+    results := make([]SpecResult, len(suite.Tests))
+    for i, test := range suite.Tests {
+        result := &results[i]
+        result.Name = test.Name
+        result.Expected = test.Expected
+        if opts.Skip != nil && opts.Skip(test) {
+            result.Skipped = true
+            continue
+        }
+
+        parseOpts := opts.Options
+        parseOpts.Partials = &StaticProvider{test.Partials}
+        tmpl, err := ParseStringOptions(test.Template, parseOpts)
+        if err != nil {
+            result.Err = err
+            continue
+        }
+        var buf bytes.Buffer
+        result.Err = tmpl.FRender(&buf, test.Data)
+        result.Output = buf.String()
+        result.Passed = result.Err == nil && result.Output == test.Expected
+    }
+    return results, nil
+}
+
+// RunSpecFile runs the spec suite in the named file.
+func RunSpecFile(filename string, opts SpecOptions) ([]SpecResult, error) {
+    f, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return RunSpec(f, opts)
+}
+
+// SpecReport is the outcome of CheckSpecCompliance.
+type SpecReport struct {
+    // Suites holds the results of each suite, in the order of their files'
+    // names.
+    Suites []SpecSuiteResult
+    // Passed, Failed and Skipped count the tests of all suites.
+    Passed, Failed, Skipped int
+}
+
+// SpecSuiteResult holds the results of a suite of CheckSpecCompliance.
+type SpecSuiteResult struct {
+    // Name is the name of the suite's file, without the .json extension,
+    // such as "sections" or "~lambdas" for an optional module.
+    Name    string
+    Results []SpecResult
+}
+
+// CheckSpecCompliance runs every suite of the mustache spec found in specs,
+// which are the .json files of its specs directory, with the given
+// options, and reports which tests pass. It shows what enabling options
+// such as SpecStrict, or extensions, gains or loses in compliance. specs may
+// be os.DirFS of a checkout of the spec, or an embed.FS of its files.
+func CheckSpecCompliance(specs fs.FS, opts SpecOptions) (SpecReport, error) {
+    var report SpecReport
+    names, err := fs.Glob(specs, "*.json")
+    if err != nil {
+        return report, err
+    }
+    for _, name := range names {
+        f, err := specs.Open(name)
+        if err != nil {
+            return report, err
+        }
+        results, err := RunSpec(f, opts)
+        f.Close()
+        if err != nil {
+            return report, fmt.Errorf("%s: %v", name, err)
+        }
+        for _, result := range results {
+            switch {
+            case result.Skipped:
+                report.Skipped++
+            case result.Passed:
+                report.Passed++
+            default:
+                report.Failed++
+            }
+        }
+        report.Suites = append(report.Suites, SpecSuiteResult{strings.TrimSuffix(name, ".json"), results})
+    }
+    return report, nil
+}
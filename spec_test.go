@@ -0,0 +1,128 @@
+package mustache
+
+import (
+    "strings"
+    "testing"
+    "testing/fstest"
+)
+
+const specSuite = `{
+  "overview": "A small suite in the format of the mustache spec.",
+  "tests": [
+    {"name": "Basic", "data": {"subject": "world"}, "template": "Hello, {{subject}}!", "expected": "Hello, world!"},
+    {"name": "Decimal", "data": {"power": 1.210}, "template": "{{power}} jiggawatts!", "expected": "1.21 jiggawatts!"},
+    {"name": "Partial", "data": {"text": "content"}, "template": "\"{{>partial}}\"", "partials": {"partial": "*{{text}}*"}, "expected": "\"*content*\""},
+    {"name": "Wrong", "data": {}, "template": "{{a}}", "expected": "b"},
+    {"name": "Broken", "data": {}, "template": "{{#a}}", "expected": ""},
+    {"name": "Lambda", "data": {}, "template": "{{lambda}}", "expected": "world"}
+  ]
+}`
+
+func TestRunSpec(t *testing.T) {
+    opts := SpecOptions{Skip: func(test SpecTest) bool { return test.Name == "Lambda" }}
+    results, err := RunSpec(strings.NewReader(specSuite), opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    expected := []struct {
+        name    string
+        passed  bool
+        skipped bool
+        err     bool
+    }{
+        {"Basic", true, false, false},
+        {"Decimal", true, false, false},
+        {"Partial", true, false, false},
+        {"Wrong", false, false, false},
+        {"Broken", false, false, true},
+        {"Lambda", false, true, false},
+    }
+    if len(results) != len(expected) {
+        t.Fatalf("expected %d results, got %d", len(expected), len(results))
+    }
+    for i, result := range results {
+        e := expected[i]
+        if result.Name != e.name || result.Passed != e.passed || result.Skipped != e.skipped || (result.Err != nil) != e.err {
+            t.Errorf("unexpected result %+v", result)
+        }
+    }
+
+    if _, err := RunSpec(strings.NewReader("{"), opts); err == nil {
+        t.Fatal("expected an error for an invalid suite")
+    }
+}
+
+// specStrictSuite holds cases from the official spec that need SpecStrict.
+const specStrictSuite = `{
+  "tests": [
+    {"name": "Standalone Comment", "data": {}, "template": "Begin.\n{{! Comment Block! }}\nEnd.\n", "expected": "Begin.\nEnd.\n"},
+    {"name": "Indented Standalone Comment", "data": {}, "template": "Begin.\n  {{! Indented Comment Block! }}\nEnd.\n", "expected": "Begin.\nEnd.\n"},
+    {"name": "Standalone Line Endings", "data": {}, "template": "|\r\n{{! Standalone Comment }}\r\n|", "expected": "|\r\n|"},
+    {"name": "Standalone Without Previous Line", "data": {}, "template": "  {{! I'm Still Standalone }}\n!", "expected": "!"},
+    {"name": "Standalone Without Newline", "data": {}, "template": "!\n  {{! I'm Still Standalone }}", "expected": "!\n"},
+    {"name": "Multiline Standalone", "data": {}, "template": "Begin.\n{{!\nSomething's going on here...\n}}\nEnd.\n", "expected": "Begin.\nEnd.\n"},
+    {"name": "Indented Inline", "data": {}, "template": "  12 {{! 34 }}\n", "expected": "  12 \n"},
+    {"name": "Standalone Section Lines", "data": {"boolean": true}, "template": "| This Is\n{{#boolean}}\n|\n{{/boolean}}\n| A Line\n", "expected": "| This Is\n|\n| A Line\n"},
+    {"name": "Indented Standalone Section Lines", "data": {"boolean": false}, "template": "| This Is\n  {{^boolean}}\n|\n  {{/boolean}}\n| A Line\n", "expected": "| This Is\n|\n| A Line\n"},
+    {"name": "Indented Inline Sections", "data": {"boolean": true}, "template": " {{#boolean}}YES{{/boolean}}\n {{#boolean}}GOOD{{/boolean}}\n", "expected": " YES\n GOOD\n"},
+    {"name": "Standalone Delimiter Tag", "data": {}, "template": "Begin.\n{{=@ @=}}\nEnd.\n", "expected": "Begin.\nEnd.\n"},
+    {"name": "Standalone Partial Indentation", "data": {"content": "<\n->"}, "template": "\\\n {{>partial}}\n/\n", "partials": {"partial": "|\n{{{content}}}\n|\n"}, "expected": "\\\n |\n <\n->\n |\n/\n"},
+    {"name": "Failed Partial Lookup", "data": {}, "template": "\"{{>text}}\"", "expected": "\"\""},
+    {"name": "Partial Recursion", "data": {"content": "X", "nodes": [{"content": "Y", "nodes": []}]}, "template": "{{>node}}", "partials": {"node": "{{content}}<{{#nodes}}{{>node}}{{/nodes}}>"}, "expected": "X<Y<>>"},
+    {"name": "Basic Null Interpolation", "data": {"cannot": null}, "template": "I ({{cannot}}) be seen!", "expected": "I () be seen!"},
+    {"name": "HTML Escaping", "data": {"forbidden": "& \" < >"}, "template": "{{forbidden}} {{&forbidden}}", "expected": "&amp; &quot; &lt; &gt; & \" < >"},
+    {"name": "Section Context", "data": {"list": [{"name": "item"}], "bool": true, "name": "top"}, "template": "{{#list}}{{#bool}}{{name}}{{/bool}}{{/list}}", "expected": "item"}
+  ]
+}`
+
+func TestSpecStrict(t *testing.T) {
+    results, err := RunSpec(strings.NewReader(specStrictSuite), SpecOptions{Options: ParseOptions{SpecStrict: true}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, result := range results {
+        if !result.Passed {
+            t.Errorf("%s: expected %q got %q (%v)", result.Name, result.Expected, result.Output, result.Err)
+        }
+    }
+}
+
+func TestCheckSpecCompliance(t *testing.T) {
+    specs := fstest.MapFS{
+        "basics.json":      {Data: []byte(specSuite)},
+        "~standalone.json": {Data: []byte(specStrictSuite)},
+        "README.md":        {Data: []byte("not a suite")},
+    }
+    opts := SpecOptions{Skip: func(test SpecTest) bool { return test.Name == "Lambda" }}
+    report, err := CheckSpecCompliance(specs, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(report.Suites) != 2 || report.Suites[0].Name != "basics" || report.Suites[1].Name != "~standalone" {
+        t.Fatalf("unexpected suites %+v", report.Suites)
+    }
+    if report.Skipped != 1 || report.Passed+report.Failed != 22 || report.Failed == 0 {
+        t.Fatalf("unexpected counts %d passed, %d failed, %d skipped", report.Passed, report.Failed, report.Skipped)
+    }
+
+    // SpecStrict passes the standalone suite, and gains in compliance
+    opts.Options.SpecStrict = true
+    strict, err := CheckSpecCompliance(specs, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, result := range strict.Suites[1].Results {
+        if !result.Passed {
+            t.Errorf("%s: expected it to pass with SpecStrict", result.Name)
+        }
+    }
+    if strict.Passed <= report.Passed {
+        t.Fatalf("expected SpecStrict to pass more tests, got %d and %d", strict.Passed, report.Passed)
+    }
+
+    specs["broken.json"] = &fstest.MapFile{Data: []byte("{")}
+    if _, err := CheckSpecCompliance(specs, opts); err == nil || !strings.HasPrefix(err.Error(), "broken.json: ") {
+        t.Fatalf("expected an error naming the broken suite, got %v", err)
+    }
+}
@@ -0,0 +1,60 @@
+package mustache
+
+import "strings"
+
+// unparseDelims are the delimiters Unparse may write a template with, in
+// order of preference.
+var unparseDelims = [][2]string{{"{{", "}}"}, {"<%", "%>"}, {"[[", "]]"}, {"{%", "%}"}, {"<<<", ">>>"}}
+
+// Unparse writes the template back as source, such that parsing it yields
+// the same template. Whitespace inside tags is normalized, comments keep
+// their text but not their layout, and the lines SpecStrict removes around
+// standalone tags are not restored. Set delimiter tags are dropped: the
+// source uses the default delimiters, unless the text of the template
+// contains them, in which case it starts by setting others.
+func (tmpl *Template) Unparse() string {
+    nodes := flatten(tmpl.elems, nil)
+    delims := unparseDelims[len(unparseDelims)-1]
+    for _, candidate := range unparseDelims {
+        if delimitsNodes(nodes, candidate[0], candidate[1]) {
+            delims = candidate
+            break
+        }
+    }
+    otag, ctag := delims[0], delims[1]
+
+    var sb strings.Builder
+    if otag != "{{" {
+        sb.WriteString("{{=" + otag + " " + ctag + "=}}")
+    }
+    for _, n := range nodes {
+        switch n.kind {
+        case 0:
+            sb.WriteString(n.value)
+        case 'v':
+            sb.WriteString(otag + n.value + ctag)
+        case '{':
+            sb.WriteString(otag + "&" + n.value + ctag)
+        case '!':
+            sb.WriteString(otag + "! " + n.value + " " + ctag)
+        default:
+            sb.WriteString(otag + string(n.kind) + n.value + ctag)
+        }
+    }
+    return sb.String()
+}
+
+// delimitsNodes reports whether nodes can be written with the given
+// delimiters, which must not occur in text, nor start in text and end in
+// the next tag, nor occur inside tags.
+func delimitsNodes(nodes []diffNode, otag, ctag string) bool {
+    for _, n := range nodes {
+        if n.kind == 0 && strings.Index(n.value+otag, otag) < len(n.value) {
+            return false
+        }
+        if n.kind != 0 && (strings.Contains(n.value, otag) || strings.Contains(n.value, ctag)) {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,45 @@
+package mustache
+
+import "testing"
+
+func TestUnparse(t *testing.T) {
+    tests := []struct {
+        src      string
+        expected string
+    }{
+        {`Hello {{ name }}!`, `Hello {{name}}!`},
+        {`{{#items}}{{{html}}}{{& raw}}{{/items}}{{^items}}none{{/items}}`, `{{#items}}{{&html}}{{&raw}}{{/items}}{{^items}}none{{/items}}`},
+        {`{{!  a note }}{{> footer }}`, `{{! a note }}{{>footer}}`},
+        {`{{#top5 products}}{{Name}}{{/top5}}`, `{{#top5 products}}{{Name}}{{/top5 products}}`},
+        {`{{=<% %>=}}<%a%> {{literal}}`, `{{=<% %>=}}<%a%> {{literal}}`},
+        {`{{=[ ]=}}[a] {{x}} <%y%>`, `{{=[[ ]]=}}[[a]] {{x}} <%y%>`},
+        {`{{=| |=}}a{|b|`, `{{=<% %>=}}a{<%b%>`},
+        {`{{=<% %>=}}{{ <%a%> <% b %>`, `{{=<% %>=}}{{ <%a%> <%b%>`},
+    }
+    partials := &StaticProvider{map[string]string{"footer": "<footer>{{year}}</footer>"}}
+    context := map[string]interface{}{
+        "name": "World", "a": "A", "b": "B", "html": "<i>", "raw": "&",
+        "items": []int{1, 2}, "products": []map[string]string{{"Name": "tea"}},
+        "top5": Limit(5), "year": 2024,
+    }
+    for _, test := range tests {
+        tmpl, err := ParseStringPartials(test.src, partials)
+        if err != nil {
+            t.Fatal(err)
+        }
+        src := tmpl.Unparse()
+        if src != test.expected {
+            t.Fatalf("%q: expected %q got %q", test.src, test.expected, src)
+        }
+        reparsed, err := ParseStringPartials(src, partials)
+        if err != nil {
+            t.Fatalf("%q: %v", src, err)
+        }
+        if reparsed.Unparse() != src {
+            t.Fatalf("%q: unparsed again as %q", src, reparsed.Unparse())
+        }
+        if tmpl.Render(context) != reparsed.Render(context) {
+            t.Fatalf("%q: rendered %q, reparsed rendered %q", test.src, tmpl.Render(context), reparsed.Render(context))
+        }
+    }
+}
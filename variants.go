@@ -0,0 +1,110 @@
+package mustache
+
+import (
+    "fmt"
+    "hash/fnv"
+    "sort"
+)
+
+// variantSep separates the name of a template from that of its variant in
+// the names a Registry stores variants under, which no partial tag can
+// refer to.
+const variantSep = "\x00"
+
+func variantKey(name, variant string) string {
+    return name + variantSep + variant
+}
+
+// A VariantSelector chooses the variant of the template called name to
+// render, among those registered, for experiments such as A/B tests. The
+// empty string chooses the template itself.
+type VariantSelector func(name string, variants []string) string
+
+// AddVariant adds or replaces a variant of the template called name, which
+// RenderVariant may render in its place. Variants are compiled and cached
+// like templates, and include the same partials, but are not listed by
+// Names and cannot be included as partials themselves. Remove removes a
+// template's variants with it.
+func (r *Registry) AddVariant(name, variant, src string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.add(variantKey(name, variant), src, "")
+    variants := r.variants[name]
+    i := sort.SearchStrings(variants, variant)
+    if i == len(variants) || variants[i] != variant {
+        variants = append(variants[:i:i], append([]string{variant}, variants[i:]...)...)
+    }
+    r.variants[name] = variants
+}
+
+// Variants returns the names of the variants of the template called name,
+// sorted.
+func (r *Registry) Variants(name string) []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    return append([]string(nil), r.variants[name]...)
+}
+
+// GetVariant returns the source of the variant of the template called name.
+func (r *Registry) GetVariant(name, variant string) (string, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    src, ok := r.sources[variantKey(name, variant)]
+    if !ok {
+        return "", fmt.Errorf("Could not find variant %q of template %q", variant, name)
+    }
+    return src, nil
+}
+
+// RenderVariant renders the variant of the template called name that choose
+// selects, returning the variant rendered so that it can be recorded with
+// the experiment's results. If choose selects no variant, or one that is
+// not registered, the template itself is rendered and the variant is "".
+func (r *Registry) RenderVariant(name string, choose VariantSelector, context ...interface{}) (output, variant string, err error) {
+    variants := r.Variants(name)
+    if choose != nil && len(variants) > 0 {
+        variant = choose(name, variants)
+    }
+    key := name
+    if i := sort.SearchStrings(variants, variant); variant != "" && i < len(variants) && variants[i] == variant {
+        key = variantKey(name, variant)
+    } else {
+        variant = ""
+    }
+    output, err = r.render(key, context)
+    return output, variant, err
+}
+
+// BucketSelector returns a VariantSelector that assigns key, such as a user
+// ID, to a variant in proportion to weights, which maps variants to their
+// shares; the template itself is weighted by the "" entry. The same key
+// always gets the same variant, for the same weights, so that a user sees
+// one variant throughout an experiment. Variants missing from weights are
+// never chosen.
+func BucketSelector(key string, weights map[string]int) VariantSelector {
+    return func(name string, variants []string) string {
+        choices := append([]string{""}, variants...)
+        total := 0
+        for _, v := range choices {
+            if weights[v] > 0 {
+                total += weights[v]
+            }
+        }
+        if total == 0 {
+            return ""
+        }
+        h := fnv.New32a()
+        h.Write([]byte(name + variantSep + key))
+        bucket := int(h.Sum32() % uint32(total))
+        for _, v := range choices {
+            if weights[v] <= 0 {
+                continue
+            }
+            if bucket < weights[v] {
+                return v
+            }
+            bucket -= weights[v]
+        }
+        return ""
+    }
+}
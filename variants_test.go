@@ -0,0 +1,73 @@
+package mustache
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestVariants(t *testing.T) {
+    r := NewRegistry()
+    r.Add("page", "A {{>header}}")
+    r.AddVariant("page", "b", "B {{>header}}")
+    r.AddVariant("page", "a2", "A2")
+    r.Add("header", "{{name}}")
+
+    if names := strings.Join(r.Names(), ","); names != "header,page" {
+        t.Fatalf("expected variants not to be listed, got %s", names)
+    }
+    if variants := strings.Join(r.Variants("page"), ","); variants != "a2,b" {
+        t.Fatalf("expected variants a2 and b, got %s", variants)
+    }
+    tests := []struct {
+        choose   VariantSelector
+        expected string
+        variant  string
+    }{
+        {nil, "A Bob", ""},
+        {func(string, []string) string { return "b" }, "B Bob", "b"},
+        {func(string, []string) string { return "missing" }, "A Bob", ""},
+        {BucketSelector("user-1", map[string]int{"b": 100}), "B Bob", "b"},
+        {BucketSelector("user-1", map[string]int{"": 1}), "A Bob", ""},
+        {BucketSelector("user-1", nil), "A Bob", ""},
+    }
+    for i, test := range tests {
+        output, variant, err := r.RenderVariant("page", test.choose, map[string]string{"name": "Bob"})
+        if err != nil || output != test.expected || variant != test.variant {
+            t.Fatalf("%d: expected %q from %q, got %q from %q, %v", i, test.expected, test.variant, output, variant, err)
+        }
+    }
+
+    // variants include the registry's partials, and are recompiled when
+    // one changes
+    r.Add("header", "[{{name}}]")
+    if output, _, _ := r.RenderVariant("page", BucketSelector("u", map[string]int{"b": 1}), map[string]string{"name": "Bob"}); output != "B [Bob]" {
+        t.Fatalf("expected the new header, got %q", output)
+    }
+
+    // a bucket selector splits keys by weight, and always gives a key the
+    // same variant
+    counts := map[string]int{}
+    weights := map[string]int{"": 50, "b": 30, "a2": 20}
+    for i := 0; i < 10000; i++ {
+        key := strings.Repeat("x", i%7) + string(rune('a'+i%26)) + strings.Repeat("y", i/26)
+        choose := BucketSelector(key, weights)
+        variant := choose("page", []string{"a2", "b"})
+        if again := choose("page", []string{"a2", "b"}); again != variant {
+            t.Fatalf("%s: expected %q again, got %q", key, variant, again)
+        }
+        counts[variant]++
+    }
+    for variant, weight := range weights {
+        if n := counts[variant]; n < weight*100-500 || n > weight*100+500 {
+            t.Fatalf("expected about %d renders of %q, got %d", weight*100, variant, n)
+        }
+    }
+
+    r.Remove("page")
+    if len(r.Variants("page")) != 0 {
+        t.Fatal("expected Remove to remove the variants")
+    }
+    if _, _, err := r.RenderVariant("page", nil); err == nil {
+        t.Fatal("expected an error for a removed template")
+    }
+}